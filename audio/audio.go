@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"shooter-duel/game"
+)
+
+// Sound is a sound effect
+type Sound int
+
+// Sound effects
+const (
+	SoundShot Sound = iota
+	SoundHit
+	SoundGameOver
+)
+
+func (s Sound) String() string {
+	switch s {
+	case SoundShot:
+		return "shot"
+	case SoundHit:
+		return "hit"
+	case SoundGameOver:
+		return "game over"
+	}
+	return "unknown sound"
+}
+
+// Backend plays sound effects
+type Backend interface {
+	Play(s Sound) error
+}
+
+// =============================================================================
+// BACKENDS
+// =============================================================================
+
+// BellInterval is the shortest time between two rings of the bell for
+// shots, so holding fire doesn't turn into one continuous beep
+const BellInterval = 150 * time.Millisecond
+
+// Bell plays every sound as the terminal bell. Terminals can't vary the
+// bell, so it only tells that something happened.
+type Bell struct {
+	out  io.Writer
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewBell creates a bell ringing on the given terminal output
+func NewBell(out io.Writer) *Bell {
+	return &Bell{out: out}
+}
+
+// Play rings the bell, skipping shots that follow the last ring too closely
+func (b *Bell) Play(s Sound) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	if s == SoundShot && now.Sub(b.last) < BellInterval {
+		return nil
+	}
+	b.last = now
+	_, err := b.out.Write([]byte("\a"))
+	return err
+}
+
+// Silent plays nothing, for tests and terminals without a bell
+type Silent struct{}
+
+// Play does nothing
+func (Silent) Play(Sound) error {
+	return nil
+}
+
+// =============================================================================
+// PLAYER
+// =============================================================================
+
+// Player plays the sounds of game events on a backend unless muted
+type Player struct {
+	backend Backend
+	mu      sync.Mutex
+	muted   bool
+}
+
+// NewPlayer creates a player for the backend
+func NewPlayer(backend Backend, muted bool) *Player {
+	return &Player{backend: backend, muted: muted}
+}
+
+// Muted reports whether sounds are turned off
+func (p *Player) Muted() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.muted
+}
+
+// ToggleMute turns sounds off or back on and returns whether they are off
+func (p *Player) ToggleMute() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.muted = !p.muted
+	return p.muted
+}
+
+// Play plays a sound unless muted
+func (p *Player) Play(s Sound) error {
+	if p.Muted() {
+		return nil
+	}
+	return p.backend.Play(s)
+}
+
+// HandleEvent plays the sound of a game event; subscribe it to the match's
+// event bus
+func (p *Player) HandleEvent(ev game.Event) {
+	if s, ok := EventSound(ev); ok {
+		p.Play(s)
+	}
+}
+
+// EventSound returns the sound effect of a game event, if it has one
+func EventSound(ev game.Event) (Sound, bool) {
+	switch ev.Kind {
+	case game.EventShot:
+		return SoundShot, true
+	case game.EventHit, game.EventDeath:
+		return SoundHit, true
+	case game.EventMatchEnd:
+		return SoundGameOver, true
+	}
+	return 0, false
+}
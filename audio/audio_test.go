@@ -0,0 +1,84 @@
+package audio
+
+import (
+	"bytes"
+	"shooter-duel/game"
+	"testing"
+	"time"
+)
+
+// recorder is a backend remembering the sounds played
+type recorder struct {
+	played []Sound
+}
+
+func (r *recorder) Play(s Sound) error {
+	r.played = append(r.played, s)
+	return nil
+}
+
+func TestEventSounds(t *testing.T) {
+	rec := &recorder{}
+	player := NewPlayer(rec, false)
+
+	events := []game.Event{
+		{Kind: game.EventShot},
+		{Kind: game.EventHit},
+		{Kind: game.EventRoundEnd},
+		{Kind: game.EventDeath},
+		{Kind: game.EventMatchEnd},
+	}
+	for _, ev := range events {
+		player.HandleEvent(ev)
+	}
+
+	expected := []Sound{SoundShot, SoundHit, SoundHit, SoundGameOver}
+	if len(rec.played) != len(expected) {
+		t.Fatalf("Expected sounds %v, got %v", expected, rec.played)
+	}
+	for i, s := range expected {
+		if rec.played[i] != s {
+			t.Errorf("Sound %d: expected %s, got %s", i, s, rec.played[i])
+		}
+	}
+}
+
+func TestMute(t *testing.T) {
+	rec := &recorder{}
+	player := NewPlayer(rec, true)
+
+	player.Play(SoundHit)
+	if len(rec.played) != 0 {
+		t.Error("A muted player should stay silent")
+	}
+	if player.ToggleMute() || player.Muted() {
+		t.Error("Toggling should unmute")
+	}
+	player.Play(SoundHit)
+	if len(rec.played) != 1 {
+		t.Errorf("Expected one sound after unmuting, got %d", len(rec.played))
+	}
+
+	if err := NewPlayer(Silent{}, false).Play(SoundGameOver); err != nil {
+		t.Errorf("Silent backend should not fail: %v", err)
+	}
+}
+
+func TestBell(t *testing.T) {
+	var out bytes.Buffer
+	bell := NewBell(&out)
+
+	// shots in quick succession ring once, other sounds always ring
+	bell.Play(SoundShot)
+	bell.Play(SoundShot)
+	bell.Play(SoundHit)
+	if out.String() != "\a\a" {
+		t.Errorf("Expected two rings, got %q", out.String())
+	}
+
+	bell.last = time.Now().Add(-BellInterval)
+	bell.Play(SoundShot)
+	if out.String() != "\a\a\a" {
+		t.Errorf("Expected a ring for a later shot, got %q", out.String())
+	}
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// AppName names the per-user configuration directory
+const AppName = "shooter-duel"
+
+// Files kept in the configuration directory
+const (
+	CertificateFile = "host_cert.pem"
+	PrivateKeyFile  = "host_key.pem"
+	KnownHostsFile  = "known_hosts"
+	LogFile         = "shooter-duel.log"
+)
+
+// Dir returns the per-user configuration directory, creating it if needed
+func Dir() (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(base, AppName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Path returns the location of a named file in the configuration directory
+func Path(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
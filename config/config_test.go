@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPushHistory(t *testing.T) {
+	entries := []string{"a", "b", "c"}
+
+	// pushing an existing entry moves it to the front
+	result := PushHistory(entries, "c", 10)
+	expected := []string{"c", "a", "b"}
+	if len(result) != len(expected) {
+		t.Fatalf("Expected %d entries, got %d", len(expected), len(result))
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Errorf("Expected entry %d to be %q, got %q", i, expected[i], result[i])
+		}
+	}
+
+	// history is trimmed to the maximum size
+	result = PushHistory(entries, "d", 2)
+	if len(result) != 2 || result[0] != "d" || result[1] != "a" {
+		t.Errorf("Expected [d a], got %v", result)
+	}
+}
+
+func TestHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), HostHistoryFile)
+
+	// a missing file is an empty history
+	entries, err := LoadHistory(path)
+	if err != nil {
+		t.Fatalf("Failed to load missing history: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected empty history, got %v", entries)
+	}
+
+	if err := SaveHistory(path, []string{"192.168.1.5:8080", "localhost:8080"}); err != nil {
+		t.Fatalf("Failed to save history: %v", err)
+	}
+
+	entries, err = LoadHistory(path)
+	if err != nil {
+		t.Fatalf("Failed to load history: %v", err)
+	}
+	if len(entries) != 2 || entries[0] != "192.168.1.5:8080" {
+		t.Errorf("Unexpected history entries: %v", entries)
+	}
+}
+
+func TestRememberHost(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	if err := RememberHost("10.0.0.2:8080"); err != nil {
+		t.Fatalf("Failed to remember host: %v", err)
+	}
+	if err := RememberHost("10.0.0.3:8080"); err != nil {
+		t.Fatalf("Failed to remember host: %v", err)
+	}
+
+	hosts := LoadHostHistory()
+	if len(hosts) != 2 || hosts[0] != "10.0.0.3:8080" {
+		t.Errorf("Expected newest host first, got %v", hosts)
+	}
+}
+
+func TestPlayerName(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	if err := SavePlayerName("Ana"); err != nil {
+		t.Fatalf("Failed to save player name: %v", err)
+	}
+	if got := LoadPlayerName(); got != "Ana" {
+		t.Errorf("Expected Ana, got %q", got)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	// defaults on first run
+	if got := LoadSettings(); got != DefaultSettings() {
+		t.Errorf("Expected the default settings, got %+v", got)
+	}
+
+	if err := SaveSettings(Settings{Sound: false}); err != nil {
+		t.Fatalf("Failed to save settings: %v", err)
+	}
+	if got := LoadSettings(); got.Sound {
+		t.Error("Expected sound to stay off")
+	}
+
+	// settings missing from an older file keep their defaults
+	path, _ := Path(SettingsFile)
+	os.WriteFile(path, []byte(`{"sound": false}`), 0o600)
+	if got := LoadSettings(); got.Theme != DefaultSettings().Theme {
+		t.Errorf("Expected theme %q, got %q", DefaultSettings().Theme, got.Theme)
+	}
+
+	// a broken file falls back to the defaults
+	os.WriteFile(path, []byte("{sound"), 0o600)
+	if got := LoadSettings(); got != DefaultSettings() {
+		t.Errorf("Expected the default settings for a broken file, got %+v", got)
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+const (
+	// HostHistoryFile stores recently joined host addresses, newest first
+	HostHistoryFile = "hosts_history"
+	// MaxHostHistory is the number of host addresses remembered
+	MaxHostHistory = 10
+)
+
+// LoadHistory reads a history file with one entry per line. A missing file
+// yields an empty history.
+func LoadHistory(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// SaveHistory writes history entries to a file, one per line
+func SaveHistory(path string, entries []string) error {
+	data := strings.Join(entries, "\n")
+	if len(entries) > 0 {
+		data += "\n"
+	}
+	return os.WriteFile(path, []byte(data), 0o600)
+}
+
+// PushHistory moves entry to the front of the history, dropping duplicates
+// and trimming the list to limit entries
+func PushHistory(entries []string, entry string, limit int) []string {
+	result := []string{entry}
+	for _, e := range entries {
+		if e != entry && len(result) < limit {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// LoadHostHistory returns the recently joined host addresses, newest first
+func LoadHostHistory() []string {
+	path, err := Path(HostHistoryFile)
+	if err != nil {
+		return []string{}
+	}
+	entries, err := LoadHistory(path)
+	if err != nil {
+		return []string{}
+	}
+	return entries
+}
+
+// RememberHost records a successfully joined host address
+func RememberHost(address string) error {
+	path, err := Path(HostHistoryFile)
+	if err != nil {
+		return err
+	}
+	entries, err := LoadHistory(path)
+	if err != nil {
+		return err
+	}
+	return SaveHistory(path, PushHistory(entries, address, MaxHostHistory))
+}
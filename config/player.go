@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"os/user"
+	"strings"
+)
+
+// PlayerNameFile stores the display name used in the lobby
+const PlayerNameFile = "player_name"
+
+// LoadPlayerName returns the saved display name, falling back to the
+// login name on first run
+func LoadPlayerName() string {
+	if path, err := Path(PlayerNameFile); err == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			if name := strings.TrimSpace(string(data)); name != "" {
+				return name
+			}
+		}
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// SavePlayerName remembers the display name for the next session
+func SavePlayerName(name string) error {
+	path, err := Path(PlayerNameFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(name+"\n"), 0o600)
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const (
+	// SettingsFile stores the user's preferences
+	SettingsFile = "settings.json"
+	// ThemesFile holds the player's own color themes
+	ThemesFile = "themes.txt"
+	// SpritesDir holds the player's sprite packs, one NAME.txt file each
+	SpritesDir = "sprites"
+)
+
+// Settings are the user's preferences. Fields missing from the file keep
+// their defaults, so new settings can be added without breaking old files.
+type Settings struct {
+	Sound   bool   `json:"sound"`   // play sound effects
+	Theme   string `json:"theme"`   // name of the color theme
+	Sprites string `json:"sprites"` // name of the sprite pack, empty for the built-in sprites
+	Ship    string `json:"ship"`    // name of the ship picked in the lobby
+}
+
+// DefaultSettings returns the preferences used on first run
+func DefaultSettings() Settings {
+	return Settings{Sound: true, Theme: "classic"}
+}
+
+// LoadSettings returns the saved preferences, or the defaults if the file
+// is missing or unreadable
+func LoadSettings() Settings {
+	settings := DefaultSettings()
+	path, err := Path(SettingsFile)
+	if err != nil {
+		return settings
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return settings
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return DefaultSettings()
+	}
+	return settings
+}
+
+// SaveSettings writes the preferences for the next session
+func SaveSettings(settings Settings) error {
+	path, err := Path(SettingsFile)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
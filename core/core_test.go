@@ -3,31 +3,127 @@ package core
 import (
 	"testing"
 	"time"
+
+	"shooter-duel/game"
+
+	"github.com/nsf/termbox-go"
 )
 
-// mock termbox event for testing
-type mockEvent struct {
-	eventType int
-	key       int
-	ch        rune
+// keyEvents returns a channel preloaded with the given termbox events
+func keyEvents(evs ...termbox.Event) <-chan termbox.Event {
+	events := make(chan termbox.Event, len(evs))
+	for _, ev := range evs {
+		events <- ev
+	}
+	return events
 }
 
 func TestHandleMenuInput(t *testing.T) {
-	// TODO: implement proper termbox mocking
-	// skipping this test since it blocks on termbox.PollEvent()
-	t.Skip("Skipping test that requires termbox mocking")
+	testCases := []struct {
+		ev               termbox.Event
+		selected         int
+		expectedOption   int
+		expectedSelected bool
+	}{
+		{termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown}, 0, 1, false},
+		{termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowUp}, 0, 2, false},
+		{termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}, 1, 1, true},
+		{termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}, 0, 2, true},
+		{termbox.Event{Type: termbox.EventResize}, 1, 1, false},
+	}
+
+	for _, tc := range testCases {
+		option, selected := HandleMenuInput(keyEvents(tc.ev), tc.selected)
+		if option != tc.expectedOption || selected != tc.expectedSelected {
+			t.Errorf("Expected (%d, %v), got (%d, %v)", tc.expectedOption, tc.expectedSelected, option, selected)
+		}
+	}
 }
 
 func TestWaitForRestart(t *testing.T) {
-	// TODO: implement proper termbox mocking
-	// skipping this test since it blocks on termbox.PollEvent()
-	t.Skip("Skipping test that requires termbox mocking")
+	restart := WaitForRestart(keyEvents(
+		termbox.Event{Type: termbox.EventKey, Ch: 'x'},
+		termbox.Event{Type: termbox.EventKey, Ch: 'r'},
+	))
+	if !restart {
+		t.Error("R should restart")
+	}
+
+	restart = WaitForRestart(keyEvents(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}))
+	if restart {
+		t.Error("ESC should quit")
+	}
+}
+
+func TestControlsHeldKey(t *testing.T) {
+	c := NewControls()
+	start := time.Now()
+
+	// a single press counts as held until the repeat delay runs out
+	c.Press(KeyLeft, start)
+	if !c.Sample(start.Add(c.RepeatDelay / 2)).Left {
+		t.Error("Key should be held before the repeat delay expires")
+	}
+	if c.Sample(start.Add(c.RepeatDelay)).Left {
+		t.Error("Key should be released after the repeat delay expires")
+	}
+}
+
+func TestControlsLearnsRepeatTiming(t *testing.T) {
+	c := NewControls()
+	start := time.Now()
+
+	// simulate a terminal with a 300ms delay and 30ms repeat interval
+	now := start
+	c.Press(KeyRight, now)
+	now = now.Add(300 * time.Millisecond)
+	c.Press(KeyRight, now)
+	for i := 0; i < 5; i++ {
+		now = now.Add(30 * time.Millisecond)
+		c.Press(KeyRight, now)
+	}
+
+	if c.RepeatDelay != 375*time.Millisecond {
+		t.Errorf("Expected learned repeat delay 375ms, got %v", c.RepeatDelay)
+	}
+	if c.RepeatInterval != 90*time.Millisecond {
+		t.Errorf("Expected learned repeat interval 90ms, got %v", c.RepeatInterval)
+	}
+
+	// once repeating, the key is released shortly after the last repeat
+	if !c.Sample(now.Add(60 * time.Millisecond)).Right {
+		t.Error("Key should still be held between repeats")
+	}
+	if c.Sample(now.Add(120 * time.Millisecond)).Right {
+		t.Error("Key should be released once repeats stop")
+	}
 }
 
-func TestReadInputFromTerminal(t *testing.T) {
-	// TODO: implement proper termbox mocking
-	// skipping this test since it blocks on termbox.PollEvent()
-	t.Skip("Skipping test that requires termbox mocking")
+func TestControlsOppositeDirection(t *testing.T) {
+	c := NewControls()
+	now := time.Now()
+
+	c.Press(KeyLeft, now)
+	c.Press(KeyRight, now.Add(10*time.Millisecond))
+
+	in := c.Sample(now.Add(20 * time.Millisecond))
+	if in.Left || !in.Right {
+		t.Errorf("Pressing right should release left, got %+v", in)
+	}
+}
+
+func TestControlsFireTap(t *testing.T) {
+	c := NewControls()
+	now := time.Now()
+
+	// a tap fires on the next tick only
+	c.Press(KeyFire, now)
+	if !c.Sample(now.Add(game.TickInterval)).Fire {
+		t.Error("Tap should fire on the next tick")
+	}
+	if c.Sample(now.Add(2 * game.TickInterval)).Fire {
+		t.Error("Tap should fire only once")
+	}
 }
 
 func TestInputChannelCommunication(t *testing.T) {
@@ -120,3 +216,18 @@ func TestInputStringValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestQuickMessage(t *testing.T) {
+	if msg, ok := QuickMessage(termbox.Event{Type: termbox.EventKey, Ch: '1'}); !ok || msg != "gg" {
+		t.Errorf("Expected 'gg' on key 1, got %q", msg)
+	}
+	if msg, ok := QuickMessage(termbox.Event{Type: termbox.EventKey, Ch: '2'}); !ok || msg != "rematch?" {
+		t.Errorf("Expected 'rematch?' on key 2, got %q", msg)
+	}
+	if _, ok := QuickMessage(termbox.Event{Type: termbox.EventKey, Ch: '9'}); ok {
+		t.Error("Unbound number keys should not send anything")
+	}
+	if _, ok := QuickMessage(termbox.Event{Type: termbox.EventKey, Ch: 'a'}); ok {
+		t.Error("Letters are not quick messages")
+	}
+}
@@ -1,35 +1,184 @@
 package core
 
 import (
+	"time"
+
+	"shooter-duel/game"
+
 	"github.com/nsf/termbox-go"
 )
 
-// ReadInputFromTerminal reads user input from the terminal
-func ReadInputFromTerminal(inputChan chan string) {
-	for {
-		ev := termbox.PollEvent()
-		if ev.Type == termbox.EventKey {
-			if ev.Key == termbox.KeyEsc || ev.Ch == 'q' {
-				inputChan <- "quit"
-				return
-			}
-			if ev.Ch == 'a' {
-				inputChan <- "move_left"
-			}
-			if ev.Ch == 'd' {
-				inputChan <- "move_right"
-			}
-			if ev.Ch == 'j' {
-				inputChan <- "shoot"
-			}
+// Key bindings for in-game controls
+const (
+	KeyLeft  = 'a'
+	KeyRight = 'd'
+	KeyFire  = 'j'
+	KeyChat  = 't'
+	KeyMute  = 'm'
+)
+
+// QuickMessages are canned chat messages sent with the number keys 1-5
+var QuickMessages = []string{"gg", "rematch?", "nice shot!", "oops", "good luck!"}
+
+// QuickMessage returns the canned chat message bound to a number key
+func QuickMessage(ev termbox.Event) (string, bool) {
+	if ev.Type != termbox.EventKey || ev.Ch < '1' || ev.Ch > '9' {
+		return "", false
+	}
+	i := int(ev.Ch - '1')
+	if i >= len(QuickMessages) {
+		return "", false
+	}
+	return QuickMessages[i], true
+}
+
+// Default press timeouts used until the terminal's repeat timing is learned
+const (
+	// DefaultRepeatDelay is how long a key counts as held after the first press,
+	// covering the pause before the terminal starts auto-repeating
+	DefaultRepeatDelay = 500 * time.Millisecond
+	// DefaultRepeatInterval is how long a key counts as held after a repeat event
+	DefaultRepeatInterval = 100 * time.Millisecond
+
+	// Bounds for learned timeouts; quick double taps look like very short
+	// repeat delays, so the delay has a higher floor than the interval
+	minRepeatDelay    = 150 * time.Millisecond
+	minRepeatInterval = 30 * time.Millisecond
+	maxRepeatTimeout  = time.Second
+)
+
+// PollEvents forwards termbox events on the returned channel. It must be
+// started once; every screen reads its input from the same channel so no
+// goroutine is left behind stealing events from the next screen.
+func PollEvents() <-chan termbox.Event {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			events <- termbox.PollEvent()
+		}
+	}()
+	return events
+}
+
+// IsQuitKey reports whether the event asks to leave the current game
+func IsQuitKey(ev termbox.Event) bool {
+	return ev.Type == termbox.EventKey && (ev.Key == termbox.KeyEsc || ev.Ch == 'q')
+}
+
+// keyPress tracks the press events seen for one held key
+type keyPress struct {
+	first   time.Time
+	last    time.Time
+	repeats int
+}
+
+// Controls turns discrete key press events into a held-key input state.
+// Terminals report no key releases, so a key counts as held until a timeout
+// after its last press event. The timeouts adapt to the repeat delay and
+// interval observed from the terminal.
+type Controls struct {
+	RepeatDelay    time.Duration
+	RepeatInterval time.Duration
+
+	keys    map[rune]*keyPress
+	pressed map[rune]bool // presses not yet seen by Sample
+}
+
+// NewControls creates a controls tracker with the default press timeouts
+func NewControls() *Controls {
+	return &Controls{
+		RepeatDelay:    DefaultRepeatDelay,
+		RepeatInterval: DefaultRepeatInterval,
+		keys:           make(map[rune]*keyPress),
+		pressed:        make(map[rune]bool),
+	}
+}
+
+// Press records a key press event at the given time
+func (c *Controls) Press(ch rune, now time.Time) {
+	c.pressed[ch] = true
+
+	// Moving one way releases the other direction immediately
+	switch ch {
+	case KeyLeft:
+		delete(c.keys, KeyRight)
+	case KeyRight:
+		delete(c.keys, KeyLeft)
+	}
+
+	k, ok := c.keys[ch]
+	if !ok || !c.held(k, now) {
+		c.keys[ch] = &keyPress{first: now, last: now}
+		return
+	}
+
+	// The key was still held, so this is an auto-repeat: learn the timing.
+	// A first repeat arriving faster than any real repeat delay means the
+	// delay itself was missed, so it only teaches the interval.
+	if k.repeats == 0 {
+		if gap := now.Sub(k.first); gap >= minRepeatDelay {
+			c.RepeatDelay = clampTimeout(gap*5/4, minRepeatDelay)
+		}
+	} else {
+		c.RepeatInterval = clampTimeout(now.Sub(k.last)*3, minRepeatInterval)
+	}
+	k.last = now
+	k.repeats++
+}
+
+// Held reports whether the key is considered held at the given time
+func (c *Controls) Held(ch rune, now time.Time) bool {
+	k, ok := c.keys[ch]
+	return ok && c.held(k, now)
+}
+
+// Sample returns the input state for the current tick. Fire is set when the
+// fire key was pressed since the last sample or is auto-repeating, so a
+// single tap fires exactly once.
+func (c *Controls) Sample(now time.Time) game.InputState {
+	in := game.InputState{
+		Left:  c.Held(KeyLeft, now),
+		Right: c.Held(KeyRight, now),
+		Fire:  c.pressed[KeyFire],
+	}
+	if k, ok := c.keys[KeyFire]; ok && k.repeats > 0 && c.held(k, now) {
+		in.Fire = true
+	}
+
+	for ch := range c.pressed {
+		delete(c.pressed, ch)
+	}
+	for ch, k := range c.keys {
+		if !c.held(k, now) {
+			delete(c.keys, ch)
 		}
 	}
+	return in
+}
+
+// held applies the press timeout heuristic to a tracked key
+func (c *Controls) held(k *keyPress, now time.Time) bool {
+	timeout := c.RepeatInterval
+	if k.repeats == 0 {
+		timeout = c.RepeatDelay
+	}
+	return now.Sub(k.last) < timeout
+}
+
+// clampTimeout keeps a learned timeout within sane bounds
+func clampTimeout(d, floor time.Duration) time.Duration {
+	if d < floor {
+		return floor
+	}
+	if d > maxRepeatTimeout {
+		return maxRepeatTimeout
+	}
+	return d
 }
 
 // WaitForRestart waits for the user to press R to restart or Q to exit
-func WaitForRestart() bool {
-	for {
-		ev := termbox.PollEvent()
+func WaitForRestart(events <-chan termbox.Event) bool {
+	for ev := range events {
 		if ev.Type == termbox.EventKey {
 			if ev.Ch == 'r' || ev.Ch == 'R' {
 				return true
@@ -42,11 +191,12 @@ func WaitForRestart() bool {
 			}
 		}
 	}
+	return false
 }
 
 // HandleMenuInput handles user input in the menu
-func HandleMenuInput(selectedOption int) (int, bool) {
-	ev := termbox.PollEvent()
+func HandleMenuInput(events <-chan termbox.Event, selectedOption int) (int, bool) {
+	ev := <-events
 	if ev.Type == termbox.EventKey {
 		switch ev.Key {
 		case termbox.KeyEnter:
@@ -0,0 +1,89 @@
+package game
+
+// EventKind is the kind of something that happened during a tick
+type EventKind string
+
+// Event kinds. There is no event for taking a power-up because the game
+// has no power-ups yet; it comes with them.
+const (
+	EventShot     EventKind = "shot"      // a player fired a bullet
+	EventHit      EventKind = "hit"       // a bullet hit a player who survived it
+	EventDeath    EventKind = "death"     // a bullet took a player's last health
+	EventRoundEnd EventKind = "round_end" // a round ended and the next one follows
+	EventMatchEnd EventKind = "match_end" // the last round ended
+)
+
+// Event is something that happened during a tick. The host sends the
+// events of each tick to the client with the state, so consumers on both
+// sides learn what happened without comparing states.
+type Event struct {
+	Kind     EventKind
+	Tick     int     // GameState.Elapsed when it happened
+	PlayerID int     // who fired, was hit or died, or won the round or match (0 for a draw)
+	SourceID int     `json:",omitempty"` // who fired the bullet of a hit or death
+	X, Y     float64 `json:",omitempty"` // where a shot, hit or death happened
+}
+
+// emit records an event of the current tick
+func emit(gs *GameState, ev Event) {
+	ev.Tick = gs.Elapsed
+	gs.Events = append(gs.Events, ev)
+}
+
+// EventBus hands the events of each tick to the consumers that subscribed
+// to it, in the order they subscribed
+type EventBus struct {
+	handlers []func(Event)
+}
+
+// NewEventBus creates a bus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe adds a consumer called for every published event
+func (b *EventBus) Subscribe(handler func(Event)) {
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish hands events to every consumer
+func (b *EventBus) Publish(events []Event) {
+	for _, ev := range events {
+		for _, handler := range b.handlers {
+			handler(ev)
+		}
+	}
+}
+
+// =============================================================================
+// MATCH STATISTICS
+// =============================================================================
+
+// MatchStats counts what each player did during a match, by player ID
+type MatchStats struct {
+	Shots map[int]int
+	Hits  map[int]int // bullets that hit the opponent
+}
+
+// NewMatchStats creates empty statistics
+func NewMatchStats() *MatchStats {
+	return &MatchStats{Shots: make(map[int]int), Hits: make(map[int]int)}
+}
+
+// Record counts one event; subscribe it to the match's event bus
+func (s *MatchStats) Record(ev Event) {
+	switch ev.Kind {
+	case EventShot:
+		s.Shots[ev.PlayerID]++
+	case EventHit, EventDeath:
+		s.Hits[ev.SourceID]++
+	}
+}
+
+// Accuracy returns the share of a player's shots that hit, in percent
+func (s *MatchStats) Accuracy(playerID int) int {
+	if s.Shots[playerID] == 0 {
+		return 0
+	}
+	return s.Hits[playerID] * 100 / s.Shots[playerID]
+}
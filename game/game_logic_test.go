@@ -1,7 +1,9 @@
 package game
 
 import (
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInitGame(t *testing.T) {
@@ -40,26 +42,88 @@ func TestHandlePlayerInput(t *testing.T) {
 	initialX := player.X
 
 	// Test left movement
-	HandlePlayerInput(gs, player, "move_left")
-	if player.X >= initialX {
-		t.Error("Player should move left")
+	HandlePlayerInput(gs, player, InputState{Left: true})
+	if player.X != initialX-Params.PlayerSpeed {
+		t.Errorf("Player should move left by %f, got X=%f", Params.PlayerSpeed, player.X)
 	}
 
 	// Test right movement (after moving left)
 	leftX := player.X
-	HandlePlayerInput(gs, player, "move_right")
+	HandlePlayerInput(gs, player, InputState{Right: true})
 	if player.X <= leftX {
 		t.Error("Player should move right")
 	}
 
+	// Test that holding both directions cancels out
+	bothX := player.X
+	HandlePlayerInput(gs, player, InputState{Left: true, Right: true})
+	if player.X != bothX {
+		t.Error("Player should not move when both directions are held")
+	}
+
 	// Test shooting
 	initialBullets := len(gs.Bullets)
-	HandlePlayerInput(gs, player, "shoot")
+	HandlePlayerInput(gs, player, InputState{Fire: true})
 	if len(gs.Bullets) != initialBullets+1 {
 		t.Error("Should create a new bullet when shooting")
 	}
 }
 
+func TestHeldMovementPerTick(t *testing.T) {
+	gs := InitGame(true, 80, 24)
+	player := gs.Players[0]
+	initialX := player.X
+
+	// holding right for several ticks moves at PlayerSpeed per tick
+	ticks := 5
+	for i := 0; i < ticks; i++ {
+		HandlePlayerInput(gs, player, InputState{Right: true})
+	}
+
+	expected := initialX + float64(ticks)*Params.PlayerSpeed
+	if player.X != expected {
+		t.Errorf("Expected X %f after %d ticks, got %f", expected, ticks, player.X)
+	}
+}
+
+func TestFireCooldown(t *testing.T) {
+	gs := InitGame(true, 80, 24)
+	player := gs.Players[0]
+
+	// holding fire shoots once per cooldown period
+	ticks := Params.FireCooldown * 3
+	for i := 0; i < ticks; i++ {
+		HandlePlayerInput(gs, player, InputState{Fire: true})
+	}
+
+	if len(gs.Bullets) != 3 {
+		t.Errorf("Expected 3 bullets after %d ticks of held fire, got %d", ticks, len(gs.Bullets))
+	}
+}
+
+func TestInputStateEncoding(t *testing.T) {
+	testCases := []InputState{
+		{},
+		{Left: true},
+		{Right: true, Fire: true},
+		{Left: true, Right: true, Fire: true},
+	}
+
+	for _, tc := range testCases {
+		parsed, err := ParseInputState(tc.String())
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tc.String(), err)
+		}
+		if parsed != tc {
+			t.Errorf("Expected %+v, got %+v", tc, parsed)
+		}
+	}
+
+	if _, err := ParseInputState("left jump"); err == nil {
+		t.Error("Unknown actions should be rejected")
+	}
+}
+
 func TestCheckCollisions(t *testing.T) {
 	gs := InitGame(true, 80, 24)
 	player := gs.Players[0]
@@ -120,4 +184,390 @@ func TestUpdateGame(t *testing.T) {
 	if bullet.Y != initialY+bullet.Speed {
 		t.Errorf("Bullet should move down, expected %f, got %f", initialY+bullet.Speed, bullet.Y)
 	}
+	if gs.Elapsed != 1 {
+		t.Errorf("Expected 1 elapsed tick, got %d", gs.Elapsed)
+	}
+}
+
+func TestMatchSettings(t *testing.T) {
+	settings := DefaultSettings()
+	if err := settings.Validate(); err != nil {
+		t.Errorf("Default settings should be valid: %v", err)
+	}
+
+	settings.Map = "moon"
+	if settings.Validate() == nil {
+		t.Error("Unknown map should be rejected")
+	}
+
+	// cycling wraps around in both directions
+	if got := Cycle(RoundChoices, 5, 1); got != 1 {
+		t.Errorf("Expected 1 after 5, got %d", got)
+	}
+	if got := Cycle(RoundChoices, 1, -1); got != 5 {
+		t.Errorf("Expected 5 before 1, got %d", got)
+	}
+
+	if got := (MatchSettings{Rounds: 3}).WinsNeeded(); got != 2 {
+		t.Errorf("Best of 3 should need 2 wins, got %d", got)
+	}
+}
+
+func TestBestOfThree(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Rounds = 3
+	gs := InitMatch(true, 80, 24, settings)
+	gs.Players[0].Name = "Ana"
+
+	// player 1 takes the first round
+	gs.Players[1].Alive = false
+	CheckGameOver(gs)
+	if gs.IsGameOver {
+		t.Fatal("Match should not end after one round of three")
+	}
+	if gs.RoundOver == 0 || gs.Message != "Round 1: Ana wins" {
+		t.Errorf("Expected the round result on hold, got %d ticks and %q", gs.RoundOver, gs.Message)
+	}
+
+	// input is ignored until the next round starts
+	x := gs.Players[0].X
+	HandlePlayerInput(gs, gs.Players[0], InputState{Left: true})
+	if gs.Players[0].X != x {
+		t.Error("Players should not move between rounds")
+	}
+
+	for gs.RoundOver > 0 {
+		UpdateGame(gs)
+	}
+	if gs.Round != 2 || !gs.Players[1].Alive || gs.Players[1].Health != settings.Health {
+		t.Errorf("Expected round 2 with both players restored, got round %d", gs.Round)
+	}
+
+	// the second win decides the match
+	gs.Players[1].Alive = false
+	CheckGameOver(gs)
+	if !gs.IsGameOver || gs.Winner != 1 || gs.Players[0].Wins != 2 {
+		t.Errorf("Expected player 1 to win the match, got over=%v winner=%d", gs.IsGameOver, gs.Winner)
+	}
+}
+
+func TestObstaclesStopBullets(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Map = MapPillars
+	gs := InitMatch(true, 80, 24, settings)
+	if len(gs.Obstacles) == 0 {
+		t.Fatal("Pillars map should have obstacles")
+	}
+
+	o := gs.Obstacles[0]
+	gs.Bullets = append(gs.Bullets, &Bullet{X: float64(o.X), Y: float64(o.Y), Speed: 1, OwnerID: 1})
+	CheckCollisions(gs)
+	if len(gs.Bullets) != 0 {
+		t.Error("Bullet should be stopped by the obstacle")
+	}
+
+	// obstacles never overlap the players' rows
+	for _, name := range MapNames {
+		for _, o := range MapObstacles(name, 80, 24) {
+			for _, p := range gs.Players {
+				if o.Y < int(p.Y)+p.Hitbox.Height && o.Y+o.Height > int(p.Y) {
+					t.Errorf("Obstacle %+v on map %s overlaps player %d", o, name, p.ID)
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		input, expected string
+	}{
+		{"  Ana  ", "Ana"},
+		{"", "Player"},
+		{"bad\x1b[31mname", "bad[31mname"},
+		{"a very long player name indeed", "a very long play"},
+	}
+	for _, tt := range tests {
+		if got := SanitizeName(tt.input, "Player"); got != tt.expected {
+			t.Errorf("SanitizeName(%q): expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestLobbyCountdown(t *testing.T) {
+	now := time.Now()
+	lobby := NewLobby("Ana")
+	lobby.SetReady(SeatHost, true, now)
+	if lobby.Countdown != 0 {
+		t.Error("Countdown should wait for the guest")
+	}
+
+	lobby.Join("Bo")
+	lobby.SetReady(SeatGuest, true, now)
+	if lobby.Countdown != CountdownSeconds {
+		t.Errorf("Expected a %d second countdown, got %d", CountdownSeconds, lobby.Countdown)
+	}
+
+	// changing the settings cancels the countdown and clears ready flags
+	settings := lobby.Settings
+	settings.Rounds = 3
+	lobby.SetSettings(settings)
+	if lobby.Countdown != 0 || lobby.Players[SeatHost].Ready || lobby.Players[SeatGuest].Ready {
+		t.Error("Changing settings should reset the ready flags")
+	}
+
+	lobby.SetReady(SeatHost, true, now)
+	lobby.SetReady(SeatGuest, true, now)
+	if lobby.Update(now.Add(1500 * time.Millisecond)) {
+		t.Error("Match should not start before the countdown ends")
+	}
+	if lobby.Countdown != 2 {
+		t.Errorf("Expected 2 seconds left, got %d", lobby.Countdown)
+	}
+	if !lobby.Update(now.Add(CountdownSeconds * time.Second)) {
+		t.Error("Match should start when the countdown ends")
+	}
+	if lobby.Update(now.Add(5 * time.Second)) {
+		t.Error("Match should start only once")
+	}
+
+	gs := lobby.NewMatch(80, 24)
+	if gs.Players[0].Name != "Ana" || gs.Players[1].Name != "Bo" || gs.Settings.Rounds != 3 {
+		t.Errorf("Match should use the lobby's names and settings, got %q %q", gs.Players[0].Name, gs.Players[1].Name)
+	}
+}
+
+func TestSwapSides(t *testing.T) {
+	gs := InitGame(true, 80, 24)
+	hostY, clientY := gs.Players[0].Y, gs.Players[1].Y
+	SwapSides(gs)
+	if gs.Players[0].Y != clientY || gs.Players[1].Y != hostY {
+		t.Errorf("Expected players swapped, got Y %f and %f", gs.Players[0].Y, gs.Players[1].Y)
+	}
+
+	// the host now fires down from the top
+	HandlePlayerInput(gs, gs.Players[0], InputState{Fire: true})
+	if len(gs.Bullets) != 1 || gs.Bullets[0].Speed <= 0 {
+		t.Error("Player at the top should fire downwards")
+	}
+}
+
+func TestArena(t *testing.T) {
+	for _, arena := range ArenaSizes {
+		if err := arena.Validate(); err != nil {
+			t.Errorf("Preset arena %s should be valid: %v", arena, err)
+		}
+	}
+	if (Arena{Width: 10, Height: 5}).Validate() == nil {
+		t.Error("Tiny arenas should be rejected")
+	}
+	if (Arena{Width: 100000, Height: 24}).Validate() == nil {
+		t.Error("Huge arenas should be rejected")
+	}
+
+	if !DefaultArena.Fits(80, 24) || DefaultArena.Fits(79, 24) || DefaultArena.Fits(80, 23) {
+		t.Error("Default arena should exactly fit an 80x24 terminal with its hud and help line")
+	}
+	if got := DefaultArena.Terminal(); got != (Arena{Width: 80, Height: 24}) {
+		t.Errorf("Expected an 80x24 terminal, got %s", got)
+	}
+}
+
+func TestHitEvents(t *testing.T) {
+	gs := InitGame(true, 80, 24)
+	gs.Players[1].Health = 2
+	target := gs.Players[1]
+	shoot := func() {
+		gs.Bullets = append(gs.Bullets, &Bullet{X: target.X + 1, Y: target.Y + 1, OwnerID: 1})
+		Step(gs)
+	}
+
+	// a hit the player survives
+	shoot()
+	if len(gs.Events) != 1 || gs.Events[0].Kind != EventHit || gs.Events[0].PlayerID != target.ID || gs.Events[0].SourceID != 1 {
+		t.Fatalf("Expected a hit event for player %d, got %+v", target.ID, gs.Events)
+	}
+
+	// the last hit is a death at the center of the ship, which ends the match
+	shoot()
+	if len(gs.Events) != 2 || gs.Events[0].Kind != EventDeath || gs.Events[1].Kind != EventMatchEnd {
+		t.Fatalf("Expected death and match end events, got %+v", gs.Events)
+	}
+	ev := gs.Events[0]
+	if ev.X != target.X+float64(target.Hitbox.Width)/2 || ev.Y != target.Y+float64(target.Hitbox.Height)/2 {
+		t.Errorf("Expected the death at the ship's center, got %v,%v", ev.X, ev.Y)
+	}
+
+	// events only last one tick
+	Step(gs)
+	if len(gs.Events) != 0 {
+		t.Errorf("Expected no events on a quiet tick, got %+v", gs.Events)
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	settings := DefaultSettings()
+	settings.Rounds = 3
+	settings.Health = 1
+	gs := InitMatch(true, 80, 24, settings)
+
+	bus := NewEventBus()
+	stats := NewMatchStats()
+	var kinds []EventKind
+	bus.Subscribe(stats.Record)
+	bus.Subscribe(func(ev Event) { kinds = append(kinds, ev.Kind) })
+
+	// player 1 fires at player 2 until the bullet lands
+	bus.Publish(Step(gs, InputState{Fire: true}, InputState{}))
+	shooter := gs.Players[0]
+	gs.Players[1].X = shooter.X
+	for tick := 0; tick < 40 && gs.RoundOver == 0; tick++ {
+		bus.Publish(Step(gs, InputState{}, InputState{}))
+	}
+
+	expected := []EventKind{EventShot, EventDeath, EventRoundEnd}
+	if len(kinds) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, kinds)
+	}
+	for i, kind := range expected {
+		if kinds[i] != kind {
+			t.Errorf("Event %d: expected %s, got %s", i, kind, kinds[i])
+		}
+	}
+	if last := gs.Events[len(gs.Events)-1]; last.PlayerID != shooter.ID || last.Tick != gs.Elapsed {
+		t.Errorf("Expected player %d to win the round on tick %d, got %+v", shooter.ID, gs.Elapsed, last)
+	}
+
+	if stats.Shots[1] != 1 || stats.Hits[1] != 1 || stats.Accuracy(1) != 100 || stats.Accuracy(2) != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestSpritePack(t *testing.T) {
+	pack := DefaultSpritePack()
+	if err := pack.Complete(); err != nil {
+		t.Fatalf("Expected the built-in pack to be complete: %v", err)
+	}
+	for _, ship := range pack.Ships {
+		if err := ValidateShip(ship); err != nil {
+			t.Errorf("Built-in ship %s: %v", ship.Name, err)
+		}
+	}
+
+	// hitboxes come from the art
+	if hb := pack.DefaultShip(0).Hitbox(); hb != (Hitbox{Width: 5, Height: 3}) {
+		t.Errorf("Expected a 5x3 ship, got %+v", hb)
+	}
+	if hb := pack.Bullet.Hitbox(); hb != (Hitbox{Width: 1, Height: 1}) {
+		t.Errorf("Expected a 1x1 bullet, got %+v", hb)
+	}
+
+	// lines are padded, wide runes take two cells and frames animate
+	custom, err := ParseSpritePack(strings.NewReader("# mine\n\n[ship bug]\n(字)\n /\n---\n(字)\n \\\n\n[bullet]\n|\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse pack: %v", err)
+	}
+	bug := custom.Ships[0]
+	if hb := bug.Hitbox(); hb != (Hitbox{Width: 4, Height: 2}) {
+		t.Errorf("Expected a 4x2 ship, got %+v", hb)
+	}
+	if bug.Frame(1)[1] != ` \  ` || bug.Frame(2)[1] != ` /  ` {
+		t.Errorf("Expected padded frames that wrap around, got %q", bug.Frames)
+	}
+
+	// another pack replaces the sections it has
+	merged := pack.Merge(custom)
+	if len(merged.Ships) != len(pack.Ships)+1 || merged.Bullet.Frame(0)[0] != "|" || len(merged.Explosion.Frames) == 0 {
+		t.Errorf("Expected the custom ship and bullet added to the built-in pack, got %+v", merged)
+	}
+
+	bad := []string{
+		"art first\n[bullet]\n|",
+		"[ship huge]\n12345678",
+		"[ship tall]\n|\n|\n|\n|",
+		"[ship uneven]\n|\n---\n|\n|",
+		"[ship]\n|",
+		"[ship twin]\n|\n[ship twin]\n|",
+		"[shield]\n()",
+		"[bullet]\n",
+	}
+	for _, text := range bad {
+		if _, err := ParseSpritePack(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestShipChoice(t *testing.T) {
+	lobby := NewLobby("Ana")
+	lobby.Join("Bo")
+	wasp, _ := Sprites.Ship("wasp")
+	if err := lobby.SetShip(SeatGuest, wasp); err != nil {
+		t.Fatalf("Failed to pick a ship: %v", err)
+	}
+	if err := lobby.SetShip(SeatHost, NewSprite("blob", []string{"0123456789"})); err == nil {
+		t.Error("Expected an oversized ship to be rejected")
+	}
+
+	// a smaller ship keeps its player at the bottom edge
+	small := NewSprite("dot", []string{"o"})
+	lobby.SetShip(SeatHost, small)
+	gs := lobby.NewMatch(80, 22)
+	host, guest := gs.Players[SeatHost], gs.Players[SeatGuest]
+	if host.Hitbox != (Hitbox{Width: 1, Height: 1}) || host.Y != 20 {
+		t.Errorf("Expected a 1x1 host ship on row 20, got %+v on row %g", host.Hitbox, host.Y)
+	}
+	if gs.Sprite(guest.Sprite).Name != "wasp" || guest.Y != 2 {
+		t.Errorf("Expected the guest's wasp at the top, got %s on row %g", gs.Sprite(guest.Sprite).Name, guest.Y)
+	}
+
+	// rematches swap sides along the edges
+	SwapSides(gs)
+	if host.Y != 2 || guest.Y != 18 {
+		t.Errorf("Expected the ships swapped to rows 2 and 18, got %g and %g", host.Y, guest.Y)
+	}
+}
+
+func TestMatchStart(t *testing.T) {
+	lobby := NewLobby("Ana")
+	lobby.Join("Bo")
+	saucer, _ := Sprites.Ship("saucer")
+	lobby.SetShip(SeatGuest, saucer)
+	host := lobby.NewMatch(80, 22)
+
+	// the client learns the ships once and resolves the snapshot's IDs
+	client, err := JoinMatch(host.Start(), 80, 22)
+	if err != nil {
+		t.Fatalf("Failed to join the match: %v", err)
+	}
+	guest := client.Players[SeatGuest]
+	if got := client.Sprite(guest.Sprite); got.Name != "saucer" {
+		t.Errorf("Expected the guest's saucer, got %q", got.Name)
+	}
+	if got := client.Sprite(client.Players[SeatHost].Sprite); got.Name != Sprites.DefaultShip(SeatHost).Name {
+		t.Errorf("Expected the host's default ship, got %q", got.Name)
+	}
+
+	// a hostile or buggy host's match is refused
+	huge := NewSprite("huge", []string{strings.Repeat("#", MaxShipWidth+1)})
+	bad := map[string]func(*MatchStart){
+		"unprintable ship": func(s *MatchStart) { s.Ships[SeatHost] = NewSprite("broken", []string{"\x07"}) },
+		"oversized ship":   func(s *MatchStart) { s.Ships[SeatGuest] = huge },
+		"missing ship":     func(s *MatchStart) { s.Ships = s.Ships[:1] },
+		"invalid health":   func(s *MatchStart) { s.Settings.Health = 1000 },
+		"unknown map":      func(s *MatchStart) { s.Settings.Map = "void" },
+	}
+	for name, corrupt := range bad {
+		start := host.Start()
+		start.Ships = append([]Sprite{}, start.Ships...)
+		corrupt(&start)
+		if gs, err := JoinMatch(start, 80, 22); err == nil || gs != nil {
+			t.Errorf("Expected a match with %s to be refused", name)
+		}
+	}
+
+	// bullets are drawn with the local pack and unknown IDs have no art
+	if client.Sprite(SpriteBullet).Frame(0) == nil || client.Sprite(SpriteShip+5).Frames != nil {
+		t.Error("Expected the bullet resolved and unknown IDs empty")
+	}
 }
@@ -0,0 +1,62 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+)
+
+// =============================================================================
+// PLAYER INPUT STATE
+// =============================================================================
+
+// InputState holds the controls a player is holding during one simulation tick
+type InputState struct {
+	Left  bool
+	Right bool
+	Fire  bool
+}
+
+// Input action names used on the wire
+const (
+	ActionLeft  = "left"
+	ActionRight = "right"
+	ActionFire  = "fire"
+	ActionNone  = "none"
+)
+
+// String encodes the input state as a space separated list of held actions
+func (in InputState) String() string {
+	actions := []string{}
+	if in.Left {
+		actions = append(actions, ActionLeft)
+	}
+	if in.Right {
+		actions = append(actions, ActionRight)
+	}
+	if in.Fire {
+		actions = append(actions, ActionFire)
+	}
+	if len(actions) == 0 {
+		return ActionNone
+	}
+	return strings.Join(actions, " ")
+}
+
+// ParseInputState decodes an input state produced by InputState.String
+func ParseInputState(s string) (InputState, error) {
+	var in InputState
+	for _, action := range strings.Fields(s) {
+		switch action {
+		case ActionLeft:
+			in.Left = true
+		case ActionRight:
+			in.Right = true
+		case ActionFire:
+			in.Fire = true
+		case ActionNone:
+		default:
+			return InputState{}, fmt.Errorf("unknown input action %q", action)
+		}
+	}
+	return in, nil
+}
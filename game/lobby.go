@@ -0,0 +1,176 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// =============================================================================
+// LOBBY
+// =============================================================================
+
+// CountdownSeconds is how long the countdown runs once both players are ready
+const CountdownSeconds = 3
+
+// Lobby seats, matching the player IDs in the match
+const (
+	SeatHost  = 0
+	SeatGuest = 1
+)
+
+// LobbyPlayer is one player waiting in the lobby
+type LobbyPlayer struct {
+	Name      string
+	Ship      Sprite
+	Ready     bool
+	Connected bool
+}
+
+// Lobby is the state shared by both players between connecting and the
+// start of the match. The host owns it and sends it to the client on every
+// change.
+type Lobby struct {
+	Players   [2]LobbyPlayer
+	Settings  MatchSettings
+	Countdown int // seconds left before the match starts, 0 when not counting
+
+	countdownStart time.Time
+}
+
+// NewLobby creates a lobby with the host seated and default settings
+func NewLobby(hostName string) *Lobby {
+	l := &Lobby{Settings: DefaultSettings()}
+	l.Players[SeatHost] = LobbyPlayer{Name: SanitizeName(hostName, "Player 1"), Ship: Sprites.DefaultShip(SeatHost), Connected: true}
+	return l
+}
+
+// Join seats the guest, with the ship they picked if it arrived first
+func (l *Lobby) Join(name string) {
+	ship := l.Players[SeatGuest].Ship
+	if len(ship.Frames) == 0 {
+		ship = Sprites.DefaultShip(SeatGuest)
+	}
+	l.Players[SeatGuest] = LobbyPlayer{Name: SanitizeName(name, "Player 2"), Ship: ship, Connected: true}
+}
+
+// SetShip changes a player's ship. Ships come from the players' own sprite
+// packs, so the art is checked before it is used.
+func (l *Lobby) SetShip(seat int, ship Sprite) error {
+	if err := ValidateShip(ship); err != nil {
+		return err
+	}
+	l.Players[seat].Ship = ship
+	return nil
+}
+
+// SetName changes a player's display name
+func (l *Lobby) SetName(seat int, name string) {
+	l.Players[seat].Name = SanitizeName(name, l.Players[seat].Name)
+}
+
+// SetReady changes a player's ready flag. The countdown starts once both
+// players are ready and stops as soon as either is not.
+func (l *Lobby) SetReady(seat int, ready bool, now time.Time) {
+	l.Players[seat].Ready = ready
+	if l.AllReady() {
+		if l.Countdown == 0 {
+			l.countdownStart = now
+			l.Countdown = CountdownSeconds
+		}
+	} else {
+		l.Countdown = 0
+	}
+}
+
+// SetSettings changes the match settings. Both players have to ready up
+// again so nobody starts a match they didn't agree to.
+func (l *Lobby) SetSettings(settings MatchSettings) {
+	l.Settings = settings
+	for i := range l.Players {
+		l.Players[i].Ready = false
+	}
+	l.Countdown = 0
+}
+
+// AllReady reports whether both players are seated and ready
+func (l *Lobby) AllReady() bool {
+	for _, p := range l.Players {
+		if !p.Connected || !p.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// Update advances the countdown and reports whether the match starts.
+// It returns true exactly once, when the countdown runs out.
+func (l *Lobby) Update(now time.Time) bool {
+	if l.Countdown == 0 {
+		return false
+	}
+	left := CountdownSeconds - int(now.Sub(l.countdownStart)/time.Second)
+	if left <= 0 {
+		l.Countdown = 0
+		for i := range l.Players {
+			l.Players[i].Ready = false
+		}
+		return true
+	}
+	l.Countdown = left
+	return false
+}
+
+// NewMatch starts the match agreed on in the lobby
+func (l *Lobby) NewMatch(w, h int) *GameState {
+	gs := InitMatch(true, w, h, l.Settings)
+	for i, p := range gs.Players {
+		p.Name = l.Players[i].Name
+		if len(l.Players[i].Ship.Frames) > 0 {
+			SetShip(gs, p, l.Players[i].Ship)
+		}
+	}
+	return gs
+}
+
+// MatchStart tells the client that a match begins: its settings and each
+// player's ship. The ships' art is sent once here rather than in every
+// snapshot.
+type MatchStart struct {
+	Settings MatchSettings
+	Ships    []Sprite
+}
+
+// Start returns the message that starts the match on the client
+func (gs *GameState) Start() MatchStart {
+	return MatchStart{Settings: gs.Settings, Ships: gs.Ships}
+}
+
+// Validate rejects a start message the client can't play: settings that
+// aren't among the lobby's choices, or not one valid ship per player
+func (s MatchStart) Validate() error {
+	if err := s.Settings.Validate(); err != nil {
+		return err
+	}
+	if len(s.Ships) != len(Lobby{}.Players) {
+		return fmt.Errorf("expected %d ships, got %d", len(Lobby{}.Players), len(s.Ships))
+	}
+	for _, ship := range s.Ships {
+		if err := ValidateShip(ship); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JoinMatch sets up the client's side of a match the host started. A start
+// message that doesn't validate is refused.
+func JoinMatch(start MatchStart, w, h int) (*GameState, error) {
+	if err := start.Validate(); err != nil {
+		return nil, fmt.Errorf("host sent an invalid match: %w", err)
+	}
+	gs := InitMatch(false, w, h, start.Settings)
+	for i, ship := range start.Ships {
+		SetShip(gs, gs.Players[i], ship)
+	}
+	return gs, nil
+}
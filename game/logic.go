@@ -1,76 +1,133 @@
 package game
 
+import "fmt"
+
 // =============================================================================
 // GAME LOGIC FUNCTIONS
 // =============================================================================
 
-// InitGame initializes a new game state
+// InitGame initializes a new single round game with the default settings
 func InitGame(isHost bool, w, h int) *GameState {
+	return InitMatch(isHost, w, h, DefaultSettings())
+}
+
+// InitMatch initializes a new match with the settings picked in the lobby
+func InitMatch(isHost bool, w, h int, settings MatchSettings) *GameState {
 	// Host is always at the bottom, client at the top
-	var player1, player2 *Player
-
-	if isHost {
-		// Host: player 1 at bottom
-		player1 = &Player{
-			X:      float64(w) / 4,
-			Y:      float64(h - 4), // Bottom
-			Sprite: Params.Player1Sprite,
-			Speed:  Params.PlayerSpeed,
-			Hitbox: Params.PlayerHitbox,
-			ID:     1,
-			Health: Params.PlayerHealth,
-			Alive:  true,
-		}
-		// Client: player 2 at top
-		player2 = &Player{
-			X:      float64(w) / 4 * 3,
-			Y:      2, // Top
-			Sprite: Params.Player2Sprite,
-			Speed:  Params.PlayerSpeed,
-			Hitbox: Params.PlayerHitbox,
-			ID:     2,
-			Health: Params.PlayerHealth,
-			Alive:  true,
-		}
-	} else {
-		// Client: player 1 at top
-		player1 = &Player{
-			X:      float64(w) / 4,
-			Y:      2, // Top
-			Sprite: Params.Player1Sprite,
-			Speed:  Params.PlayerSpeed,
-			Hitbox: Params.PlayerHitbox,
-			ID:     1,
-			Health: Params.PlayerHealth,
-			Alive:  true,
-		}
-		// Host: player 2 at bottom
-		player2 = &Player{
-			X:      float64(w) / 4 * 3,
-			Y:      float64(h - 4), // Bottom
-			Sprite: Params.Player2Sprite,
-			Speed:  Params.PlayerSpeed,
-			Hitbox: Params.PlayerHitbox,
-			ID:     2,
-			Health: Params.PlayerHealth,
-			Alive:  true,
-		}
-	}
+	ships := []Sprite{Sprites.DefaultShip(0), Sprites.DefaultShip(1)}
+	player1 := newPlayer(1, ships[0], !isHost, w, h, settings)
+	player2 := newPlayer(2, ships[1], isHost, w, h, settings)
 
 	players := []*Player{player1, player2}
 
 	return &GameState{
 		Players:      players,
+		Ships:        ships,
 		Bullets:      make([]*Bullet, 0),
 		ScreenWidth:  w,
 		ScreenHeight: h,
 		IsGameOver:   false,
 		Winner:       0,
+		Settings:     settings,
+		Obstacles:    MapObstacles(settings.Map, w, h),
+		Round:        1,
+	}
+}
+
+// newPlayer creates a player at the top or bottom end of the arena
+func newPlayer(id int, ship Sprite, top bool, w, h int, settings MatchSettings) *Player {
+	p := &Player{
+		X:      spawnX(id-1, w),
+		Sprite: ShipSprite(id),
+		Speed:  Params.PlayerSpeed,
+		Hitbox: ship.Hitbox(),
+		ID:     id,
+		Health: settings.Health,
+		Alive:  true,
+	}
+	p.Y = spawnY(top, p.Hitbox, h)
+	return p
+}
+
+// SetShip gives a player another ship, keeping them at their end of the
+// arena
+func SetShip(gs *GameState, p *Player, ship Sprite) {
+	top := p.Y < float64(gs.ScreenHeight)/2
+	gs.Ships[p.ID-1] = ship
+	p.Sprite, p.Hitbox = ShipSprite(p.ID), ship.Hitbox()
+	p.Y = spawnY(top, p.Hitbox, gs.ScreenHeight)
+}
+
+// SwapSides moves each player to the other end of the arena, so a rematch
+// is played from the opposite side
+func SwapSides(gs *GameState) {
+	for _, p := range gs.Players {
+		top := p.Y < float64(gs.ScreenHeight)/2
+		p.Y = spawnY(!top, p.Hitbox, gs.ScreenHeight)
+	}
+}
+
+// spawnY returns the starting row of a player at the top or the bottom of
+// the arena, one row away from the edge
+func spawnY(top bool, hb Hitbox, h int) float64 {
+	if top {
+		return 2
+	}
+	return float64(h - 1 - hb.Height)
+}
+
+// spawnX returns the starting column of the player at the given index
+func spawnX(index, w int) float64 {
+	if index == 0 {
+		return float64(w) / 4
 	}
+	return float64(w) / 4 * 3
+}
+
+// startRound puts both players back at full health at their starting
+// positions and clears the arena
+func startRound(gs *GameState) {
+	for i, p := range gs.Players {
+		p.X = spawnX(i, gs.ScreenWidth)
+		p.Health = gs.Settings.Health
+		p.Alive = true
+		p.cooldown = 0
+	}
+	gs.Bullets = make([]*Bullet, 0)
+	gs.Round++
+	gs.RoundOver = 0
+	gs.Message = ""
+}
+
+// Step runs one simulation tick on the host: each player's input in player
+// order, movement, collisions and the end of rounds. It returns the events
+// of the tick, which stay in the state until the next step.
+func Step(gs *GameState, inputs ...InputState) []Event {
+	gs.Events = nil
+	for i, in := range inputs {
+		if i < len(gs.Players) {
+			HandlePlayerInput(gs, gs.Players[i], in)
+		}
+	}
+	UpdateGame(gs)
+	CheckCollisions(gs)
+	CheckGameOver(gs)
+	return gs.Events
 }
 
 // UpdateGame updates the game state (positions, bullets, etc.)
 func UpdateGame(gs *GameState) {
+	gs.Elapsed++
+
+	// Hold the result of a round on screen before starting the next one
+	if gs.RoundOver > 0 {
+		gs.RoundOver--
+		if gs.RoundOver == 0 {
+			startRound(gs)
+		}
+		return
+	}
+
 	// Update player positions
 	for _, p := range gs.Players {
 		if !p.Alive {
@@ -101,7 +158,16 @@ func CheckCollisions(gs *GameState) {
 
 	for _, bullet := range gs.Bullets {
 		hit := false
+		for _, o := range gs.Obstacles {
+			if o.Contains(bullet.X, bullet.Y) {
+				hit = true
+				break
+			}
+		}
 		for _, player := range gs.Players {
+			if hit {
+				break
+			}
 			if !player.Alive {
 				continue
 			}
@@ -114,9 +180,14 @@ func CheckCollisions(gs *GameState) {
 				bullet.OwnerID != player.ID {
 
 				player.Health--
+				ev := Event{Kind: EventHit, PlayerID: player.ID, SourceID: bullet.OwnerID, X: bullet.X, Y: bullet.Y}
 				if player.Health <= 0 {
 					player.Alive = false
+					ev.Kind = EventDeath
+					ev.X = player.X + float64(player.Hitbox.Width)/2
+					ev.Y = player.Y + float64(player.Hitbox.Height)/2
 				}
+				emit(gs, ev)
 				hit = true
 				break
 			}
@@ -130,58 +201,124 @@ func CheckCollisions(gs *GameState) {
 	gs.Bullets = bulletsToKeep
 }
 
-// CheckGameOver checks if the game has ended
+// CheckGameOver checks if the round or the whole match has ended
 func CheckGameOver(gs *GameState) {
+	if gs.IsGameOver || gs.RoundOver > 0 {
+		return
+	}
+
 	alivePlayers := 0
-	lastAlivePlayer := 0
+	var lastAlive *Player
 
 	for _, player := range gs.Players {
 		if player.Alive {
 			alivePlayers++
-			lastAlivePlayer = player.ID
+			lastAlive = player
 		}
 	}
+	if alivePlayers > 1 {
+		return
+	}
 
-	if alivePlayers <= 1 {
+	// Both players dying on the same tick is a drawn round
+	roundWinner := 0
+	if alivePlayers == 1 {
+		lastAlive.Wins++
+		roundWinner = lastAlive.ID
+	}
+
+	// The match ends once a player has won enough rounds or all rounds are
+	// played, in which case the player ahead wins (or nobody on a tie)
+	if leader, wins := matchLeader(gs); wins >= gs.Settings.WinsNeeded() || gs.Round >= gs.Settings.Rounds {
 		gs.IsGameOver = true
-		if alivePlayers == 1 {
-			gs.Winner = lastAlivePlayer
+		gs.Winner = leader
+		emit(gs, Event{Kind: EventMatchEnd, PlayerID: leader})
+		return
+	}
+	emit(gs, Event{Kind: EventRoundEnd, PlayerID: roundWinner})
+
+	gs.RoundOver = RoundPause
+	if roundWinner == 0 {
+		gs.Message = fmt.Sprintf("Round %d: draw", gs.Round)
+	} else {
+		gs.Message = fmt.Sprintf("Round %d: %s wins", gs.Round, playerName(gs, roundWinner))
+	}
+}
+
+// matchLeader returns the player with the most round wins and their wins,
+// or 0 if the players are tied
+func matchLeader(gs *GameState) (int, int) {
+	leader, most, tied := 0, -1, false
+	for _, p := range gs.Players {
+		switch {
+		case p.Wins > most:
+			leader, most, tied = p.ID, p.Wins, false
+		case p.Wins == most:
+			tied = true
 		}
 	}
+	if tied {
+		return 0, most
+	}
+	return leader, most
 }
 
-// HandlePlayerInput processes player input
-func HandlePlayerInput(gs *GameState, p *Player, input string) {
-	if !p.Alive {
+// playerName returns the display name of a player, or "Player N"
+func playerName(gs *GameState, id int) string {
+	for _, p := range gs.Players {
+		if p.ID == id && p.Name != "" {
+			return p.Name
+		}
+	}
+	return fmt.Sprintf("Player %d", id)
+}
+
+// HandlePlayerInput applies one tick of held input to the player
+func HandlePlayerInput(gs *GameState, p *Player, in InputState) {
+	if !p.Alive || gs.RoundOver > 0 || gs.IsGameOver {
 		return
 	}
 
-	switch input {
-	case "move_left":
+	if p.cooldown > 0 {
+		p.cooldown--
+	}
+
+	// Holding both directions cancels out
+	if in.Left && !in.Right {
 		p.X -= p.Speed
-	case "move_right":
+	}
+	if in.Right && !in.Left {
 		p.X += p.Speed
-	case "shoot":
-		// Determine bullet direction based on player position
-		var bulletY float64
-		var bulletSpeed float64
-
-		if p.Y < 10 { // Player at the top (client)
-			bulletY = float64(p.Y + float64(p.Hitbox.Height)) // Shoot from the bottom of the sprite
-			bulletSpeed = Params.BulletSpeed                  // Positive speed to go down
-		} else { // Player at the bottom (host)
-			bulletY = float64(p.Y - 1)        // Shoot from the top of the sprite
-			bulletSpeed = -Params.BulletSpeed // Negative speed to go up
-		}
+	}
 
-		bullet := &Bullet{
-			X:       float64(p.X + float64(p.Hitbox.Width)/2 - 0.5), // Center the bullet horizontally
-			Y:       bulletY,
-			Sprite:  Params.BulletSprite,
-			Speed:   bulletSpeed,
-			Hitbox:  Params.BulletHitbox,
-			OwnerID: p.ID,
-		}
-		gs.Bullets = append(gs.Bullets, bullet)
+	if in.Fire && p.cooldown == 0 {
+		fireBullet(gs, p)
+		p.cooldown = Params.FireCooldown
+	}
+}
+
+// fireBullet spawns a bullet travelling away from the player
+func fireBullet(gs *GameState, p *Player) {
+	// Determine bullet direction based on player position
+	var bulletY float64
+	var bulletSpeed float64
+
+	if p.Y < float64(gs.ScreenHeight)/2 { // Player at the top
+		bulletY = float64(p.Y + float64(p.Hitbox.Height)) // Shoot from the bottom of the sprite
+		bulletSpeed = gs.Settings.BulletSpeed             // Positive speed to go down
+	} else { // Player at the bottom
+		bulletY = p.Y - float64(Sprites.Bullet.Hitbox().Height) // Shoot from the top of the sprite
+		bulletSpeed = -gs.Settings.BulletSpeed                  // Negative speed to go up
+	}
+
+	bullet := &Bullet{
+		X:       float64(p.X + float64(p.Hitbox.Width)/2 - 0.5), // Center the bullet horizontally
+		Y:       bulletY,
+		Sprite:  SpriteBullet,
+		Speed:   bulletSpeed,
+		Hitbox:  Sprites.Bullet.Hitbox(),
+		OwnerID: p.ID,
 	}
+	gs.Bullets = append(gs.Bullets, bullet)
+	emit(gs, Event{Kind: EventShot, PlayerID: p.ID, X: bullet.X, Y: bullet.Y})
 }
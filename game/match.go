@@ -0,0 +1,169 @@
+package game
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// =============================================================================
+// MATCH SETTINGS
+// =============================================================================
+
+// MatchSettings are picked by the host in the lobby
+type MatchSettings struct {
+	Health      int     // hits a player can take per round
+	Rounds      int     // best of this many rounds
+	Map         string  // name of the arena layout
+	BulletSpeed float64 // cells per tick
+}
+
+// Choices offered in the lobby
+var (
+	HealthChoices      = []int{1, 3, 5, 10}
+	RoundChoices       = []int{1, 3, 5}
+	BulletSpeedChoices = []float64{0.5, 1, 1.5, 2}
+)
+
+// RoundPause is how many ticks the result of a round stays on screen
+// before the next round starts
+const RoundPause = int(2 * time.Second / TickInterval)
+
+// DefaultSettings returns the settings of a quick single round match
+func DefaultSettings() MatchSettings {
+	return MatchSettings{
+		Health:      Params.PlayerHealth,
+		Rounds:      1,
+		Map:         MapOpen,
+		BulletSpeed: Params.BulletSpeed,
+	}
+}
+
+// Validate reports settings outside the choices offered in the lobby
+func (s MatchSettings) Validate() error {
+	if indexOf(HealthChoices, s.Health) < 0 {
+		return fmt.Errorf("invalid health %d", s.Health)
+	}
+	if indexOf(RoundChoices, s.Rounds) < 0 {
+		return fmt.Errorf("invalid rounds %d", s.Rounds)
+	}
+	if indexOf(MapNames, s.Map) < 0 {
+		return fmt.Errorf("unknown map %q", s.Map)
+	}
+	if indexOf(BulletSpeedChoices, s.BulletSpeed) < 0 {
+		return fmt.Errorf("invalid bullet speed %g", s.BulletSpeed)
+	}
+	return nil
+}
+
+// WinsNeeded returns how many rounds a player must win to take the match
+func (s MatchSettings) WinsNeeded() int {
+	return s.Rounds/2 + 1
+}
+
+// Cycle returns the choice after (step > 0) or before (step < 0) the current
+// one, wrapping around; unknown values start from the first choice
+func Cycle[T comparable](choices []T, current T, step int) T {
+	i := indexOf(choices, current)
+	if i < 0 {
+		return choices[0]
+	}
+	n := len(choices)
+	return choices[((i+step)%n+n)%n]
+}
+
+func indexOf[T comparable](choices []T, v T) int {
+	for i, c := range choices {
+		if c == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// =============================================================================
+// MAPS
+// =============================================================================
+
+// Obstacle is a wall in the middle of the arena that stops bullets
+type Obstacle struct {
+	X, Y          int
+	Width, Height int
+}
+
+// Map names
+const (
+	MapOpen    = "open"
+	MapPillars = "pillars"
+	MapBunkers = "bunkers"
+)
+
+// MapNames lists the maps in the order the lobby cycles through them
+var MapNames = []string{MapOpen, MapPillars, MapBunkers}
+
+// MapObstacles lays out a map's obstacles for an arena of the given size.
+// Obstacles stay in the middle rows so they never overlap the players.
+func MapObstacles(name string, w, h int) []Obstacle {
+	mid := h / 2
+	switch name {
+	case MapPillars:
+		obstacles := []Obstacle{}
+		for i := 1; i <= 3; i++ {
+			obstacles = append(obstacles, Obstacle{X: w*i/4 - 1, Y: mid - 2, Width: 2, Height: 4})
+		}
+		return obstacles
+	case MapBunkers:
+		width := w / 6
+		return []Obstacle{
+			{X: w/4 - width/2, Y: mid - 4, Width: width, Height: 1},
+			{X: w*3/4 - width/2, Y: mid - 4, Width: width, Height: 1},
+			{X: w/2 - width/2, Y: mid, Width: width, Height: 1},
+			{X: w/4 - width/2, Y: mid + 3, Width: width, Height: 1},
+			{X: w*3/4 - width/2, Y: mid + 3, Width: width, Height: 1},
+		}
+	}
+	return nil
+}
+
+// Contains reports whether a point lies inside the obstacle
+func (o Obstacle) Contains(x, y float64) bool {
+	return x >= float64(o.X) && x < float64(o.X+o.Width) &&
+		y >= float64(o.Y) && y < float64(o.Y+o.Height)
+}
+
+// =============================================================================
+// PLAYER TEXT
+// =============================================================================
+
+const (
+	// MaxNameLength bounds a player's display name in runes
+	MaxNameLength = 16
+	// MaxChatLength bounds a chat message in runes
+	MaxChatLength = 100
+)
+
+// SanitizeText trims text typed by a player, drops control characters (so
+// nobody can send terminal escape sequences) and limits its length in runes
+func SanitizeText(text string, limit int) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text)
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// SanitizeName cleans up a display name. Empty names fall back to the given
+// default.
+func SanitizeName(name, fallback string) string {
+	if name = SanitizeText(name, MaxNameLength); name == "" {
+		return fallback
+	}
+	return name
+}
@@ -0,0 +1,325 @@
+package game
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// =============================================================================
+// SPRITES
+// =============================================================================
+
+// Size limits of a ship, so every ship fits between the arena edge and the
+// obstacles and its art fits in a lobby message
+const (
+	MaxShipWidth  = 7
+	MaxShipHeight = 3
+	MaxShipFrames = 4
+)
+
+// Sprite is a piece of ASCII art with one or more animation frames of the
+// same size. Lines are padded to the same width in terminal cells; wide
+// runes take two.
+type Sprite struct {
+	Name   string `json:",omitempty"`
+	Frames [][]string
+}
+
+// NewSprite creates a sprite from its frames, padding their lines
+func NewSprite(name string, frames ...[]string) Sprite {
+	s := Sprite{Name: name, Frames: frames}
+	width, _ := s.Size()
+	for _, frame := range s.Frames {
+		for i, line := range frame {
+			frame[i] = runewidth.FillRight(line, width)
+		}
+	}
+	return s
+}
+
+// Size returns the width in cells and the height in rows of the sprite
+func (s Sprite) Size() (width, height int) {
+	for _, frame := range s.Frames {
+		for _, line := range frame {
+			if w := runewidth.StringWidth(line); w > width {
+				width = w
+			}
+		}
+		if len(frame) > height {
+			height = len(frame)
+		}
+	}
+	return width, height
+}
+
+// Hitbox returns the area the sprite covers
+func (s Sprite) Hitbox() Hitbox {
+	width, height := s.Size()
+	return Hitbox{Width: width, Height: height}
+}
+
+// Frame returns the nth animation frame, wrapping around
+func (s Sprite) Frame(n int) []string {
+	if len(s.Frames) == 0 {
+		return nil
+	}
+	n %= len(s.Frames)
+	if n < 0 {
+		n += len(s.Frames)
+	}
+	return s.Frames[n]
+}
+
+// Validate rejects sprites without art, with frames of different sizes or
+// with characters that can't be drawn
+func (s Sprite) Validate() error {
+	if len(s.Frames) == 0 {
+		return errors.New("sprite has no frames")
+	}
+	width, height := s.Size()
+	if width == 0 {
+		return errors.New("sprite is empty")
+	}
+	for _, frame := range s.Frames {
+		if len(frame) != height {
+			return errors.New("frames differ in height")
+		}
+		for _, line := range frame {
+			if runewidth.StringWidth(line) != width {
+				return errors.New("frames differ in width")
+			}
+			for _, r := range line {
+				if !unicode.IsPrint(r) {
+					return fmt.Errorf("unprintable character %q", r)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateShip rejects ships that are no valid sprite or too large to play
+// with
+func ValidateShip(s Sprite) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.Name == "" || s.Name != SanitizeName(s.Name, "") {
+		return fmt.Errorf("invalid ship name %q", s.Name)
+	}
+	width, height := s.Size()
+	if width > MaxShipWidth || height > MaxShipHeight || len(s.Frames) > MaxShipFrames {
+		return fmt.Errorf("ship %s is too large: %dx%d with %d frames, at most %dx%d with %d", s.Name,
+			width, height, len(s.Frames), MaxShipWidth, MaxShipHeight, MaxShipFrames)
+	}
+	return nil
+}
+
+// SpriteID identifies an entity's sprite. Snapshots carry IDs rather than
+// art; each side resolves them with GameState.Sprite.
+type SpriteID uint8
+
+// Sprite IDs. The ship of the player with ID n is SpriteShip+n-1.
+const (
+	SpriteNone SpriteID = iota
+	SpriteBullet
+	SpriteShip
+)
+
+// ShipSprite returns the sprite ID of a player's ship
+func ShipSprite(playerID int) SpriteID {
+	return SpriteShip + SpriteID(playerID-1)
+}
+
+// Sprite resolves a sprite ID: bullets come from the local sprite pack and
+// ships from the ships of the match. Unknown IDs have no art.
+func (gs *GameState) Sprite(id SpriteID) Sprite {
+	switch {
+	case id == SpriteBullet:
+		return Sprites.Bullet
+	case id >= SpriteShip && int(id-SpriteShip) < len(gs.Ships):
+		return gs.Ships[id-SpriteShip]
+	}
+	return Sprite{}
+}
+
+// =============================================================================
+// SPRITE PACKS
+// =============================================================================
+
+// SpritePack is the art of a match: the ships players pick from, the
+// bullet and the explosion particle
+type SpritePack struct {
+	Ships     []Sprite
+	Bullet    Sprite
+	Explosion Sprite
+}
+
+// builtinSprites is the sprite pack shipped with the game
+//
+//go:embed sprites.txt
+var builtinSprites string
+
+// Sprites is the sprite pack in use
+var Sprites = DefaultSpritePack()
+
+// DefaultSpritePack returns the sprite pack shipped with the game
+func DefaultSpritePack() *SpritePack {
+	pack, err := ParseSpritePack(strings.NewReader(builtinSprites))
+	if err != nil {
+		panic(err)
+	}
+	return pack
+}
+
+// Ship returns the ship with the given name
+func (p *SpritePack) Ship(name string) (Sprite, bool) {
+	for _, ship := range p.Ships {
+		if ship.Name == name {
+			return ship, true
+		}
+	}
+	return Sprite{}, false
+}
+
+// ShipNames returns the names of the ships in the order of the pack
+func (p *SpritePack) ShipNames() []string {
+	names := make([]string, len(p.Ships))
+	for i, ship := range p.Ships {
+		names[i] = ship.Name
+	}
+	return names
+}
+
+// DefaultShip returns the ship of the player at the given index unless
+// they pick another: the first ship for the first player and the second,
+// if there is one, for the second
+func (p *SpritePack) DefaultShip(index int) Sprite {
+	if index < len(p.Ships) {
+		return p.Ships[index]
+	}
+	return p.Ships[0]
+}
+
+// Merge returns the pack with the sections of another replacing its own.
+// Ships are replaced one by one, by name; new ones are added at the end.
+func (p *SpritePack) Merge(other *SpritePack) *SpritePack {
+	merged := &SpritePack{Ships: append([]Sprite{}, p.Ships...), Bullet: p.Bullet, Explosion: p.Explosion}
+	for _, ship := range other.Ships {
+		replaced := false
+		for i := range merged.Ships {
+			if merged.Ships[i].Name == ship.Name {
+				merged.Ships[i], replaced = ship, true
+			}
+		}
+		if !replaced {
+			merged.Ships = append(merged.Ships, ship)
+		}
+	}
+	if len(other.Bullet.Frames) > 0 {
+		merged.Bullet = other.Bullet
+	}
+	if len(other.Explosion.Frames) > 0 {
+		merged.Explosion = other.Explosion
+	}
+	return merged
+}
+
+// ParseSpritePack reads a sprite pack file. The built-in pack describes
+// the format. Errors name the offending section.
+func ParseSpritePack(r io.Reader) (*SpritePack, error) {
+	pack := &SpritePack{}
+	var header string
+	var frames [][]string
+
+	// finish adds the section read so far to the pack
+	finish := func() error {
+		if header == "" {
+			return nil
+		}
+		for i, frame := range frames {
+			frames[i] = trimBlankLines(frame)
+		}
+		kind, name, _ := strings.Cut(header, " ")
+		sprite := NewSprite(strings.TrimSpace(name), frames...)
+		var err error
+		switch kind {
+		case "ship":
+			if err = ValidateShip(sprite); err == nil {
+				if _, ok := pack.Ship(sprite.Name); ok {
+					err = errors.New("duplicate ship")
+				}
+				pack.Ships = append(pack.Ships, sprite)
+			}
+		case "bullet":
+			pack.Bullet, err = sprite, sprite.Validate()
+		case "explosion":
+			pack.Explosion, err = sprite, sprite.Validate()
+		default:
+			err = errors.New("unknown section")
+		}
+		if err != nil {
+			return fmt.Errorf("[%s]: %w", header, err)
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(strings.TrimSpace(line), "]"):
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			header = strings.TrimSpace(strings.Trim(strings.TrimSpace(line), "[]"))
+			frames = [][]string{{}}
+		case header == "":
+			if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "#") {
+				return nil, fmt.Errorf("art before the first section: %q", line)
+			}
+		case strings.TrimSpace(line) == "---":
+			frames = append(frames, []string{})
+		default:
+			frames[len(frames)-1] = append(frames[len(frames)-1], line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// trimBlankLines drops the blank lines at the start and end of a frame
+func trimBlankLines(frame []string) []string {
+	for len(frame) > 0 && strings.TrimSpace(frame[0]) == "" {
+		frame = frame[1:]
+	}
+	for len(frame) > 0 && strings.TrimSpace(frame[len(frame)-1]) == "" {
+		frame = frame[:len(frame)-1]
+	}
+	return frame
+}
+
+// Complete reports whether the pack has everything a match needs
+func (p *SpritePack) Complete() error {
+	switch {
+	case len(p.Ships) == 0:
+		return errors.New("sprite pack has no ships")
+	case len(p.Bullet.Frames) == 0:
+		return errors.New("sprite pack has no bullet")
+	case len(p.Explosion.Frames) == 0:
+		return errors.New("sprite pack has no explosion")
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package game
 
+import (
+	"fmt"
+	"time"
+)
+
 // =============================================================================
 // GAME STRUCTURES
 // =============================================================================
@@ -10,17 +15,21 @@ type Hitbox struct {
 
 type Player struct {
 	X, Y   float64
-	Sprite []string
+	Sprite SpriteID // the ship, in GameState.Ships
 	Speed  float64
 	Hitbox Hitbox
 	ID     int
+	Name   string
 	Health int
 	Alive  bool
+	Wins   int // rounds won in this match
+
+	cooldown int // ticks until the player can fire again
 }
 
 type Bullet struct {
 	X, Y    float64
-	Sprite  []string
+	Sprite  SpriteID
 	Speed   float64
 	Hitbox  Hitbox
 	OwnerID int
@@ -34,37 +43,94 @@ type GameState struct {
 	IsGameOver   bool
 	Message      string
 	Winner       int
+	Settings     MatchSettings
+	Obstacles    []Obstacle
+	Round        int
+	RoundOver    int     // ticks left before the next round starts, 0 while playing
+	Elapsed      int     // ticks since the match started
+	Events       []Event `json:",omitempty"` // what happened during the last tick
+
+	// Ships is the art of each player's ship. It is sent once when the
+	// match starts rather than in every snapshot.
+	Ships []Sprite `json:"-"`
 }
 
 // =============================================================================
 // CONFIGURATION PARAMETERS
 // =============================================================================
 
+// TickInterval is the duration of one simulation step
+const TickInterval = 50 * time.Millisecond
+
+// Default arena size in cells; with the HUD strip above it and the help
+// line below it the match fills a classic 80x24 terminal
+const (
+	ArenaWidth  = 80
+	ArenaHeight = 22
+)
+
+// Rows the match screen draws outside the arena
+const (
+	HUDRows    = 1 // health bars, score, timer and round trip time
+	FooterRows = 1 // controls help
+)
+
+// Arena is the size of the playing field in cells. The host picks it and
+// both sides simulate and draw the match in this coordinate system, whatever
+// the size of the players' terminals.
+type Arena struct {
+	Width, Height int
+}
+
+// DefaultArena is the arena used unless the host picks another size
+var DefaultArena = Arena{Width: ArenaWidth, Height: ArenaHeight}
+
+// ArenaSizes are the arena sizes the host can pick from
+var ArenaSizes = []Arena{DefaultArena, {Width: 100, Height: 28}, {Width: 120, Height: 34}}
+
+// Bounds for arena sizes accepted from a host
+const (
+	MinArenaWidth  = 40
+	MinArenaHeight = 16
+	MaxArenaWidth  = 400
+	MaxArenaHeight = 200
+)
+
+func (a Arena) String() string {
+	return fmt.Sprintf("%dx%d", a.Width, a.Height)
+}
+
+// Validate rejects arenas too small to play in or too large to be real
+func (a Arena) Validate() error {
+	if a.Width < MinArenaWidth || a.Height < MinArenaHeight || a.Width > MaxArenaWidth || a.Height > MaxArenaHeight {
+		return fmt.Errorf("invalid arena size %s", a)
+	}
+	return nil
+}
+
+// Terminal returns the smallest terminal that shows the arena along with
+// the HUD strip and the help line
+func (a Arena) Terminal() Arena {
+	return Arena{Width: a.Width, Height: a.Height + HUDRows + FooterRows}
+}
+
+// Fits reports whether the arena can be shown on a terminal of the given size
+func (a Arena) Fits(w, h int) bool {
+	t := a.Terminal()
+	return t.Width <= w && t.Height <= h
+}
+
+// Params are the gameplay constants. Sprites and hitboxes come from the
+// sprite pack.
 var Params = struct {
-	Player1Sprite []string
-	Player2Sprite []string
-	PlayerSpeed   float64
-	PlayerHitbox  Hitbox
-	BulletSprite  []string
-	BulletSpeed   float64
-	BulletHitbox  Hitbox
-	PlayerHealth  int
+	PlayerSpeed  float64
+	BulletSpeed  float64
+	PlayerHealth int
+	FireCooldown int
 }{
-	Player1Sprite: []string{
-		` /^\ `,
-		` |'| `,
-		` /-\ `,
-	},
-	Player2Sprite: []string{
-		` \_/ `,
-		` |'| `,
-		` / \ `,
-	},
-	PlayerSpeed:  2,
-	PlayerHitbox: Hitbox{Width: 5, Height: 3},
+	PlayerSpeed:  1, // cells per tick
 	PlayerHealth: 3,
+	FireCooldown: 4, // ticks between shots while fire is held
 
-	BulletSprite: []string{`^`},
-	BulletSpeed:  1.0,
-	BulletHitbox: Hitbox{Width: 1, Height: 1},
+	BulletSpeed: 1.0,
 }
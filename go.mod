@@ -2,6 +2,7 @@ module shooter-duel
 
 go 1.24.1
 
-require github.com/nsf/termbox-go v1.1.1
-
-require github.com/mattn/go-runewidth v0.0.9 // indirect
+require (
+	github.com/mattn/go-runewidth v0.0.9
+	github.com/nsf/termbox-go v1.1.1
+)
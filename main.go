@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"math/rand"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"shooter-duel/audio"
+	"shooter-duel/config"
 	"shooter-duel/core"
 	"shooter-duel/game"
 	"shooter-duel/network"
@@ -19,8 +30,7 @@ import (
 // =============================================================================
 
 const (
-	gameOverMsg = "GAME OVER"
-	restartMsg  = "Press R to restart or Q to quit"
+	restartMsg = "Press R to restart or Q to quit"
 )
 
 // =============================================================================
@@ -36,17 +46,43 @@ func main() {
 
 	termbox.SetInputMode(termbox.InputEsc)
 	rand.Seed(time.Now().UnixNano())
+	setupLogging()
 
-	w, h := termbox.Size()
+	settings := config.LoadSettings()
+	loadSprites(settings.Sprites)
+
+	// Colors follow the player's theme in as many colors as the terminal
+	// shows
+	theme := loadTheme(settings.Theme)
+	mode := ui.DetectColorMode(os.Getenv)
+	if theme.Monochrome {
+		mode = ui.ColorsNone
+	}
+	ui.SetPalette(theme.Palette(mode))
+	log.Printf("theme %s in %s", theme.Name, mode)
+
+	// Only cells that changed since the last frame are sent to the terminal
+	screen := ui.NewDiffScreen(ui.NewTermboxScreen(mode))
+	ui.SetScreen(screen)
+
+	// Sound effects ring the terminal bell unless turned off
+	sound := audio.NewPlayer(audio.NewBell(os.Stdout), !settings.Sound)
+
+	events := core.PollEvents()
 
 	currentState := ui.StateMenu
 	menuOptionSelected := ui.MenuOptionCreate
+	var sess *session
 
 	for {
+		// Screens lay themselves out for the terminal size at the time they
+		// open; the match itself follows resizes every frame
+		w, h := termbox.Size()
+
 		switch currentState {
 		case ui.StateMenu:
 			ui.DrawMenu(menuOptionSelected, w, h)
-			newOption, selected := core.HandleMenuInput(menuOptionSelected)
+			newOption, selected := core.HandleMenuInput(events, menuOptionSelected)
 			menuOptionSelected = newOption
 			if selected {
 				if menuOptionSelected == ui.MenuOptionCreate {
@@ -59,151 +95,944 @@ func main() {
 			}
 
 		case ui.StateWaitingForClient:
+			opts, ok := createRoomForm(events, w, h)
+			if !ok {
+				currentState = ui.StateMenu
+				break
+			}
+
 			ui.DrawWaitingScreen("Creating room...", w, h)
-			conn, err := network.RunAsHost(w, h)
-
-			// It will always return an error with the IP, so handle it directly
-			if err != nil && len(err.Error()) > 20 && err.Error()[:20] == "waiting_for_connecti" {
-				hostIP := err.Error()[21:] // Extract IP from message
-				ui.DrawWaitingScreen("✅ Room created successfully!\n\n📡 Connection Info:\nIP: "+hostIP+"\nPort: "+network.Port+"\n\n⏳ Waiting for player to connect...", w, h)
-
-				// Now accept the connection
-				conn, err = network.AcceptConnection()
-				if err != nil {
-					ui.DrawGameOver(0, fmt.Sprintf("Connection Error: %s", err.Error()), restartMsg, w, h)
-					if core.WaitForRestart() {
-						currentState = ui.StateMenu
-					} else {
-						return
-					}
-				} else {
-					// If connection was successful, continue to the game
-					currentState = ui.StateGameRunning
-					gameLoop(conn, true, w, h)
-					currentState = ui.StateMenu
-				}
-			} else if err != nil {
-				// Real error
+			conn, ok, err := hostRoom(events, opts, w, h)
+			if err != nil {
 				ui.DrawGameOver(0, fmt.Sprintf("Error: %s", err.Error()), restartMsg, w, h)
-				if core.WaitForRestart() {
+				if core.WaitForRestart(events) {
 					currentState = ui.StateMenu
 				} else {
 					return
 				}
+				break
+			}
+			if !ok {
+				currentState = ui.StateMenu
+				break
 			}
 
+			guard := network.NewInputGuard(conn.RemoteAddr().String())
+			sess = &session{peer: network.NewHostPeer(conn, guard), guard: guard, seat: game.SeatHost, arena: opts.Arena}
+			currentState = ui.StateLobby
+
 		case ui.StateConnecting:
-			// Close termbox temporarily to allow console input
-			termbox.Close()
-			conn, err := network.RunAsClient(w, h)
+			joined, ok := joinRoom(events, w, h)
+			if !ok {
+				currentState = ui.StateMenu
+				break
+			}
+
+			sess = &session{peer: network.NewClientPeer(joined.Conn), seat: game.SeatGuest, arena: joined.Arena}
+			currentState = ui.StateLobby
+
+		case ui.StateLobby:
+			gs, err := runLobby(sess, w, h, events)
 			if err != nil {
-				// Reinitialize termbox to show error
-				termbox.Init()
-				termbox.SetInputMode(termbox.InputEsc)
+				sess.peer.Close()
 				ui.DrawGameOver(0, fmt.Sprintf("Error: %s", err.Error()), restartMsg, w, h)
-				if core.WaitForRestart() {
+				if core.WaitForRestart(events) {
 					currentState = ui.StateMenu
 				} else {
 					return
 				}
 				break
 			}
+			if gs == nil {
+				sess.peer.Close()
+				currentState = ui.StateMenu
+				break
+			}
+			sess.game = gs
+			currentState = ui.StateGameRunning
 
-			// Reinitialize termbox
-			termbox.Init()
-			termbox.SetInputMode(termbox.InputEsc)
+		case ui.StateGameRunning:
+			// Consumers of the match's events subscribe to its bus
+			bus := game.NewEventBus()
+			sess.stats = game.NewMatchStats()
+			bus.Subscribe(sess.stats.Record)
+			bus.Subscribe(sound.HandleEvent)
 
-			currentState = ui.StateGameRunning
-			gameLoop(conn, false, w, h)
-			currentState = ui.StateMenu
+			var quit bool
+			screen.ResetStats()
+			if sess.seat == game.SeatHost {
+				quit = hostGameLoop(sess.game, sess.peer, sess.guard, bus, sound, events)
+			} else {
+				quit = clientGameLoop(sess.game, sess.peer, bus, sound, events)
+			}
+			log.Printf("match rendering: %s", screen.Stats())
+			if quit {
+				sess.peer.Close()
+				currentState = ui.StateMenu
+				break
+			}
+			currentState = ui.StateGameOver
 
 		case ui.StateGameOver:
-			ui.DrawGameOver(0, gameOverMsg, restartMsg, w, h)
-			if core.WaitForRestart() {
+			gs, err := postGame(sess, w, h, events)
+			if err != nil || gs == nil {
+				sess.peer.Close()
 				currentState = ui.StateMenu
-			} else {
-				return
+				break
+			}
+			sess.game = gs
+			currentState = ui.StateGameRunning
+		}
+	}
+}
+
+// setupLogging sends log output to a file, since the terminal belongs to termbox
+func setupLogging() {
+	log.SetOutput(io.Discard)
+	path, err := config.Path(config.LogFile)
+	if err != nil {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	log.SetOutput(file)
+}
+
+// loadSprites adds the player's sprite pack, if they picked one, to the
+// built-in sprites. A broken pack is logged and left out.
+func loadSprites(name string) {
+	if name == "" {
+		return
+	}
+	path, err := config.Path(filepath.Join(config.SpritesDir, name+".txt"))
+	if err != nil {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("sprites: %v", err)
+		return
+	}
+	defer file.Close()
+	pack, err := game.ParseSpritePack(file)
+	if err != nil {
+		log.Printf("sprites %s: %v", name, err)
+		return
+	}
+	game.Sprites = game.Sprites.Merge(pack)
+}
+
+// pickShip selects the ship the player picked last time on the lobby
+// screen, if the sprite pack still has it, and returns the selected ship
+func pickShip(ls *ui.LobbyScreen) game.Sprite {
+	last := config.LoadSettings().Ship
+	if _, ok := game.Sprites.Ship(last); ok {
+		ls.Ship = last
+	}
+	ship, _ := game.Sprites.Ship(ls.Ship)
+	return ship
+}
+
+// saveShip remembers the ship played with for the next session, once the
+// match starts. It reads the settings afresh, like the mute key, so changes
+// saved since the lobby opened are kept.
+func saveShip(name string) {
+	settings := config.LoadSettings()
+	settings.Ship = name
+	config.SaveSettings(settings)
+}
+
+// loadTheme returns the named color theme, looking in the player's theme
+// file before the built-in themes. Unknown names and broken files fall back
+// to the default theme.
+func loadTheme(name string) *ui.Theme {
+	themes := ui.BuiltinThemes()
+	if path, err := config.Path(config.ThemesFile); err == nil {
+		if file, err := os.Open(path); err == nil {
+			own, err := ui.ParseThemes(file)
+			file.Close()
+			if err != nil {
+				log.Printf("themes: %v", err)
+			}
+			for themeName, theme := range own {
+				themes[themeName] = theme
 			}
 		}
 	}
+	if theme, ok := themes[name]; ok {
+		return theme
+	}
+	log.Printf("unknown theme %q, themes are %s", name, strings.Join(ui.ThemeNames(themes), ", "))
+	return themes[ui.DefaultTheme]
 }
 
 // =============================================================================
-// GAME LOOP FUNCTIONS
+// CONNECTION SCREENS
 // =============================================================================
 
-func gameLoop(conn net.Conn, isHost bool, w, h int) {
-	gs := game.InitGame(isHost, w, h)
-	if isHost {
-		hostGameLoop(gs, conn)
-	} else {
-		clientGameLoop(gs, conn)
+// createRoomForm asks the host for the room options
+func createRoomForm(events <-chan termbox.Event, w, h int) (network.HostOptions, bool) {
+	cs := ui.NewCreateRoomScreen()
+	for {
+		ui.DrawCreateRoomScreen(cs, w, h)
+		switch cs.HandleKey(<-events) {
+		case ui.InputCancel:
+			return network.HostOptions{}, false
+		case ui.InputSubmit:
+			return network.HostOptions{TLS: cs.TLS, Password: cs.Password.Text(), Arena: cs.Arena}, true
+		}
+	}
+}
+
+// hostRoom opens a room and shows its connection info until the opponent
+// connects and completes the handshake, or the host cancels with Esc
+func hostRoom(events <-chan termbox.Event, opts network.HostOptions, w, h int) (net.Conn, bool, error) {
+	if opts.TLS {
+		cert, err := hostCertificate()
+		if err != nil {
+			return nil, false, err
+		}
+		opts.Certificate = cert
+	}
+
+	host, err := network.Listen("")
+	if err != nil {
+		return nil, false, err
+	}
+	defer host.Close()
+
+	port := strconv.Itoa(host.Port())
+	hs := &ui.HostScreen{}
+	for _, addr := range host.Addrs() {
+		hs.Addrs = append(hs.Addrs, ui.HostAddress{
+			Interface: addr.Interface,
+			Address:   net.JoinHostPort(addr.IP.String(), port),
+			Virtual:   addr.Virtual,
+		})
+	}
+	if opts.TLS {
+		hs.Fingerprint = network.CertificateFingerprint(opts.Certificate)
+	}
+	ui.DrawHostScreen(hs, w, h)
+
+	// Announce the room on the LAN until this function returns
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if announcer, err := network.NewAnnouncer(roomName(), host.Port(), opts, network.BroadcastTargets()); err == nil {
+		go announcer.Run(ctx)
+	}
+
+	type acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan acceptResult, 1)
+	go func() {
+		// A client failing the handshake leaves the room open for the next
+		// one, and Esc drops the half-joined ones
+		session, err := host.AcceptSession(ctx, opts)
+		if err != nil {
+			accepted <- acceptResult{nil, err}
+			return
+		}
+		accepted <- acceptResult{session.Conn, nil}
+	}()
+
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type != termbox.EventKey {
+				continue
+			}
+			switch ev.Key {
+			case termbox.KeyEsc:
+				cancel()
+				if r := <-accepted; r.conn != nil {
+					r.conn.Close()
+				}
+				return nil, false, nil
+			case termbox.KeyArrowDown, termbox.KeyTab:
+				hs.Selected = (hs.Selected + 1) % len(hs.Addrs)
+			case termbox.KeyArrowUp:
+				hs.Selected = (hs.Selected - 1 + len(hs.Addrs)) % len(hs.Addrs)
+			}
+			ui.DrawHostScreen(hs, w, h)
+		case r := <-accepted:
+			if r.err != nil {
+				return nil, false, r.err
+			}
+			return r.conn, true, nil
+		}
+	}
+}
+
+// hostCertificate loads the host's TLS certificate, creating it on first run
+func hostCertificate() (tls.Certificate, error) {
+	certPath, err := config.Path(config.CertificateFile)
+	if err != nil {
+		return tls.Certificate{}, err
 	}
+	keyPath, err := config.Path(config.PrivateKeyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return network.LoadOrCreateCertificate(certPath, keyPath)
 }
 
-func hostGameLoop(gs *game.GameState, conn net.Conn) {
-	player1Input := make(chan string)
-	player2Input := make(chan string)
+// roomName names the room announced on the LAN after this machine
+func roomName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "Shooter Duel"
+	}
+	return name
+}
 
-	go core.ReadInputFromTerminal(player1Input)
-	go network.ReadInputFromNetwork(conn, player2Input)
+// joinRoom shows rooms discovered on the LAN next to the manual host address
+// form until a connection is made or the player cancels back to the menu
+func joinRoom(events <-chan termbox.Event, w, h int) (*network.Session, bool) {
+	input := ui.NewTextInput("Host IP: ", config.LoadHostHistory())
+	input.Validate = func(text string) error {
+		_, err := network.ParseHostAddress(text)
+		return err
+	}
+	js := &ui.JoinScreen{Input: input}
 
-	ticker := time.NewTicker(50 * time.Millisecond)
+	// Discovery is best effort: manual entry still works if the port is taken
+	browser, err := network.ListenForRooms("")
+	if err == nil {
+		defer browser.Close()
+		go browser.Run()
+	}
+
+	ticker := time.NewTicker(network.AnnounceInterval / 2)
 	defer ticker.Stop()
 
-	for !gs.IsGameOver {
+	for {
+		ui.DrawJoinScreen(js, w, h)
+
+		var ev termbox.Event
 		select {
-		case ev := <-player1Input:
-			if ev == "quit" {
-				gs.IsGameOver = true
-				conn.Close()
-				break
+		case <-ticker.C:
+			if browser != nil {
+				refreshRooms(js, browser.Rooms(time.Now()))
 			}
-			game.HandlePlayerInput(gs, gs.Players[0], ev)
-		case ev := <-player2Input:
-			if ev == "quit" {
-				gs.IsGameOver = true
-				conn.Close()
+			continue
+		case ev = <-events:
+		}
+
+		address, cancelled := handleJoinKey(js, ev)
+		if cancelled {
+			return nil, false
+		}
+		if address == "" {
+			continue
+		}
+
+		ui.DrawWaitingScreen("Connecting to "+address+"...", w, h)
+		session, err := connectToHost(address, func() (string, bool) {
+			return askPassword(events, address, w, h)
+		})
+		if errors.Is(err, network.ErrJoinCancelled) {
+			continue
+		}
+		var mismatch *network.FingerprintMismatchError
+		if errors.As(err, &mismatch) {
+			ui.DrawSecurityWarning(mismatch.Host, mismatch.Known, mismatch.Presented, w, h)
+			waitForKey(events)
+			continue
+		}
+		if err != nil {
+			js.ListFocused = false
+			js.Input.Error = err.Error()
+			continue
+		}
+		config.RememberHost(address)
+		return session, true
+	}
+}
+
+// connectToHost dials the host and completes the connection handshake,
+// pinning the host's certificate fingerprint on first use
+func connectToHost(address string, password func() (string, bool)) (*network.Session, error) {
+	knownHostsPath, err := config.Path(config.KnownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+	knownHosts, err := network.LoadKnownHosts(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := network.RunAsClient(address)
+	if err != nil {
+		return nil, err
+	}
+	session, err := network.ClientHandshake(conn, address, network.JoinOptions{
+		KnownHosts: knownHosts,
+		Password:   password,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The match is drawn in the host's arena, which must fit our terminal
+	if w, h := termbox.Size(); !session.Arena.Fits(w, h) {
+		session.Conn.Close()
+		return nil, fmt.Errorf("room needs a %s terminal, yours is %dx%d", session.Arena.Terminal(), w, h)
+	}
+	return session, nil
+}
+
+// askPassword prompts for the password of a protected room
+func askPassword(events <-chan termbox.Event, address string, w, h int) (string, bool) {
+	input := ui.NewTextInput("Password: ", nil)
+	input.Mask = '*'
+	for {
+		ui.DrawPasswordPrompt(input, address, w, h)
+		switch input.HandleKey(<-events) {
+		case ui.InputCancel:
+			return "", false
+		case ui.InputSubmit:
+			return input.Text(), true
+		}
+	}
+}
+
+// waitForKey blocks until any key is pressed
+func waitForKey(events <-chan termbox.Event) {
+	for ev := range events {
+		if ev.Type == termbox.EventKey {
+			return
+		}
+	}
+}
+
+// refreshRooms copies the discovered rooms into the join screen
+func refreshRooms(js *ui.JoinScreen, rooms []network.DiscoveredRoom) {
+	js.Rooms = js.Rooms[:0]
+	for _, room := range rooms {
+		js.Rooms = append(js.Rooms, ui.RoomEntry{
+			Name:       room.Name,
+			Address:    room.Address,
+			Players:    room.Players,
+			MaxPlayers: room.MaxPlayers,
+			TLS:        room.TLS,
+			Locked:     room.Locked,
+			Joinable:   room.Compatible() && room.Players < room.MaxPlayers,
+		})
+	}
+	if js.SelectedRoom >= len(js.Rooms) {
+		js.SelectedRoom = 0
+	}
+}
+
+// handleJoinKey applies a key to the join screen. It returns the address to
+// connect to once the player picks a room or submits the form.
+func handleJoinKey(js *ui.JoinScreen, ev termbox.Event) (string, bool) {
+	if ev.Type != termbox.EventKey {
+		return "", false
+	}
+	if ev.Key == termbox.KeyTab {
+		js.ListFocused = !js.ListFocused
+		return "", false
+	}
+
+	if !js.ListFocused {
+		switch js.Input.HandleKey(ev) {
+		case ui.InputCancel:
+			return "", true
+		case ui.InputSubmit:
+			address, _ := network.ParseHostAddress(js.Input.Text())
+			return address, false
+		}
+		return "", false
+	}
+
+	switch ev.Key {
+	case termbox.KeyEsc:
+		return "", true
+	case termbox.KeyArrowUp:
+		if js.SelectedRoom > 0 {
+			js.SelectedRoom--
+		}
+	case termbox.KeyArrowDown:
+		if js.SelectedRoom < len(js.Rooms)-1 {
+			js.SelectedRoom++
+		}
+	case termbox.KeyEnter:
+		if js.SelectedRoom < len(js.Rooms) && js.Rooms[js.SelectedRoom].Joinable {
+			return js.Rooms[js.SelectedRoom].Address, false
+		}
+	}
+	return "", false
+}
+
+// =============================================================================
+// LOBBY
+// =============================================================================
+
+// errOpponentLeft is reported when the other player disconnects
+var errOpponentLeft = errors.New("opponent left")
+
+// session is the connection to the opponent, shared by the lobby and the match
+type session struct {
+	peer  *network.Peer
+	guard *network.InputGuard // checks the client's messages; nil on the client
+	seat  int
+	arena game.Arena // picked by the host and sent in the handshake
+	game  *game.GameState
+
+	lobby   *game.Lobby // settings and names agreed on; host only
+	matches int         // matches played, to swap sides on every rematch
+	stats   *game.MatchStats
+}
+
+// runLobby runs our side of the lobby and returns the match to play, or
+// nil if we left
+func runLobby(sess *session, w, h int, events <-chan termbox.Event) (*game.GameState, error) {
+	if sess.seat != game.SeatHost {
+		return clientLobby(sess.peer, sess.arena, w, h, events)
+	}
+	lobby, err := hostLobby(sess.peer, sess.guard, w, h, events)
+	if lobby == nil {
+		return nil, err
+	}
+	sess.lobby = lobby
+	gs := lobby.NewMatch(sess.arena.Width, sess.arena.Height)
+	return gs, sess.peer.Send(network.MsgStart, gs.Start())
+}
+
+// postGame shows the result of the match and runs the rematch vote. It
+// returns the next match once both players agree, or nil if either leaves.
+func postGame(sess *session, w, h int, events <-chan termbox.Event) (*game.GameState, error) {
+	ps := ui.NewPostGameScreen(sess.game, sess.seat, sess.stats)
+	incoming := sess.peer.Incoming()
+
+	for {
+		// The host starts the rematch; the client waits for its start message
+		if ps.Agreed() && sess.seat == game.SeatHost {
+			sess.matches++
+			gs := sess.lobby.NewMatch(sess.arena.Width, sess.arena.Height)
+			if sess.matches%2 == 1 {
+				game.SwapSides(gs)
+			}
+			return gs, sess.peer.Send(network.MsgStart, gs.Start())
+		}
+
+		ui.DrawPostGameScreen(ps, w, h)
+
+		select {
+		case ev := <-events:
+			switch ps.HandleKey(ev) {
+			case ui.PostGameVote:
+				sess.peer.Send(network.MsgRematch, true)
+			case ui.PostGameLeave:
+				return nil, nil
+			}
+
+		case msg, ok := <-incoming:
+			if !ok || msg.Type == network.MsgQuit {
+				ps.OpponentLeft = true
+				incoming = nil
 				break
 			}
-			game.HandlePlayerInput(gs, gs.Players[1], ev)
+			switch msg.Type {
+			case network.MsgRematch:
+				var vote bool
+				if msg.Decode(&vote) == nil {
+					ps.Votes[1-sess.seat] = vote
+				}
+			case network.MsgStart:
+				var start game.MatchStart
+				if err := msg.Decode(&start); err != nil {
+					return nil, err
+				}
+				return game.JoinMatch(start, sess.arena.Width, sess.arena.Height)
+			}
+		}
+	}
+}
+
+// hostLobby runs the host's side of the lobby until the countdown ends; the
+// caller starts the match. It returns a nil lobby if the host leaves.
+func hostLobby(peer *network.Peer, guard *network.InputGuard, w, h int, events <-chan termbox.Event) (*game.Lobby, error) {
+	lobby := game.NewLobby(config.LoadPlayerName())
+	ls := ui.NewLobbyScreen(game.SeatHost, lobby.Players[game.SeatHost].Name)
+	lobby.SetShip(game.SeatHost, pickShip(ls))
+
+	// Every change is sent to the client as a full lobby snapshot
+	update := func() {
+		ls.Lobby = *lobby
+		peer.Send(network.MsgLobby, lobby)
+	}
+	update()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		ui.DrawLobbyScreen(ls, w, h)
+
+		select {
+		case ev := <-events:
+			switch ls.HandleKey(ev) {
+			case ui.LobbyLeave:
+				return nil, nil
+			case ui.LobbyRename:
+				lobby.SetName(game.SeatHost, ls.Name.Text())
+				config.SavePlayerName(lobby.Players[game.SeatHost].Name)
+				update()
+			case ui.LobbyShipChanged:
+				ship, _ := game.Sprites.Ship(ls.Ship)
+				lobby.SetShip(game.SeatHost, ship)
+				update()
+			case ui.LobbySettingsChanged:
+				lobby.SetSettings(ls.Lobby.Settings)
+				update()
+			case ui.LobbyToggleReady:
+				lobby.SetReady(game.SeatHost, !lobby.Players[game.SeatHost].Ready, time.Now())
+				update()
+			}
+
+		case msg, ok := <-peer.Incoming():
+			if !ok || msg.Type == network.MsgQuit {
+				if err := guard.Err(); err != nil {
+					return nil, fmt.Errorf("opponent kicked: %w", err)
+				}
+				return nil, errOpponentLeft
+			}
+			switch msg.Type {
+			case network.MsgName:
+				var name string
+				if msg.Decode(&name) != nil {
+					continue
+				}
+				if lobby.Players[game.SeatGuest].Connected {
+					lobby.SetName(game.SeatGuest, name)
+				} else {
+					lobby.Join(name)
+				}
+			case network.MsgReady:
+				var ready bool
+				if msg.Decode(&ready) != nil {
+					continue
+				}
+				lobby.SetReady(game.SeatGuest, ready, time.Now())
+			case network.MsgShip:
+				var ship game.Sprite
+				if msg.Decode(&ship) != nil {
+					continue
+				}
+				if err := lobby.SetShip(game.SeatGuest, ship); err != nil {
+					log.Printf("lobby: rejected the opponent's ship: %v", err)
+					continue
+				}
+			}
+			update()
+
 		case <-ticker.C:
-			game.UpdateGame(gs)
-			game.CheckCollisions(gs)
-			game.CheckGameOver(gs)
-			network.SendGameState(conn, gs)
-			ui.DrawGame(gs)
+			countdown := lobby.Countdown
+			if lobby.Update(time.Now()) {
+				saveShip(lobby.Players[game.SeatHost].Ship.Name)
+				return lobby, nil
+			}
+			if lobby.Countdown != countdown {
+				update()
+			}
 		}
 	}
 }
 
-func clientGameLoop(gs *game.GameState, conn net.Conn) {
-	playerInput := make(chan string)
-	go core.ReadInputFromTerminal(playerInput)
+// clientLobby runs the joining player's side of the lobby until the host
+// starts the match. It returns a nil game if the player leaves.
+func clientLobby(peer *network.Peer, arena game.Arena, w, h int, events <-chan termbox.Event) (*game.GameState, error) {
+	name := game.SanitizeName(config.LoadPlayerName(), "Player 2")
+	ls := ui.NewLobbyScreen(game.SeatGuest, name)
+	peer.Send(network.MsgName, name)
+	peer.Send(network.MsgShip, pickShip(ls))
 
-	go func() {
-		for {
-			if err := network.ReadGameStateFromNetwork(conn, gs); err != nil {
+	// The ship is sent once the selection settles, or when readying up, so
+	// cycling through ships doesn't flood the host
+	var settled <-chan time.Time
+	sendShip := func() {
+		ship, _ := game.Sprites.Ship(ls.Ship)
+		peer.Send(network.MsgShip, ship)
+		settled = nil
+	}
+
+	for {
+		ui.DrawLobbyScreen(ls, w, h)
+
+		select {
+		case ev := <-events:
+			switch ls.HandleKey(ev) {
+			case ui.LobbyLeave:
+				return nil, nil
+			case ui.LobbyRename:
+				name = game.SanitizeName(ls.Name.Text(), name)
+				config.SavePlayerName(name)
+				peer.Send(network.MsgName, name)
+			case ui.LobbyShipChanged:
+				settled = time.After(ui.ShipSettleDelay)
+			case ui.LobbyToggleReady:
+				if settled != nil {
+					sendShip()
+				}
+				peer.Send(network.MsgReady, !ls.Lobby.Players[game.SeatGuest].Ready)
+			}
+
+		case <-settled:
+			sendShip()
+
+		case msg, ok := <-peer.Incoming():
+			if !ok || msg.Type == network.MsgQuit {
+				return nil, errOpponentLeft
+			}
+			switch msg.Type {
+			case network.MsgLobby:
+				var lobby game.Lobby
+				if err := msg.Decode(&lobby); err != nil {
+					return nil, err
+				}
+				ls.Lobby = lobby
+			case network.MsgStart:
+				var start game.MatchStart
+				if err := msg.Decode(&start); err != nil {
+					return nil, err
+				}
+				gs, err := game.JoinMatch(start, arena.Width, arena.Height)
+				if err == nil {
+					saveShip(start.Ships[game.SeatGuest].Name)
+				}
+				return gs, err
+			}
+		}
+	}
+}
+
+// =============================================================================
+// GAME LOOP FUNCTIONS
+// =============================================================================
+
+// hostGameLoop runs the match simulation on the host and reports whether
+// the host quit
+func hostGameLoop(gs *game.GameState, peer *network.Peer, guard *network.InputGuard, bus *game.EventBus, sound *audio.Player, events <-chan termbox.Event) bool {
+	controls := core.NewControls()
+	seat := game.SeatHost
+	ms := ui.NewMatchScreen(gs, seat)
+	ms.Muted = sound.Muted()
+	bus.Subscribe(func(ev game.Event) { ms.Effects.Trigger(ev, time.Now()) })
+
+	// The client sends one input state per tick; keep the latest one and
+	// remember a fire press even if a newer state arrives before our tick
+	var remoteInput game.InputState
+
+	ticker := time.NewTicker(game.TickInterval)
+	defer ticker.Stop()
+	pinger := time.NewTicker(network.PingInterval)
+	defer pinger.Stop()
+	animation := time.NewTicker(ui.AnimationInterval)
+	defer animation.Stop()
+
+	for !gs.IsGameOver {
+		select {
+		case ev := <-events:
+			if ev.Type == termbox.EventResize {
+				ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, time.Now())
+			}
+			if handleMatchKey(ev, controls, ms, sound, peer, playerName(gs, seat), time.Now()) {
+				return true
+			}
+		case msg, ok := <-peer.Incoming():
+			if !ok || msg.Type == network.MsgQuit {
 				gs.IsGameOver = true
-				return
+				gs.Message = "Opponent left"
+				if err := guard.Err(); err != nil {
+					gs.Message = "Opponent kicked: " + err.Error()
+				}
+				break
+			}
+			switch msg.Type {
+			case network.MsgChat:
+				receiveChat(ms.Chat, msg, playerName(gs, 1-seat), time.Now())
+			case network.MsgPing, network.MsgPong:
+				ms.RTT = handleLatency(peer, msg, ms.RTT, time.Now())
+			case network.MsgInput:
+				// The guard only lets valid input through
+				in, _ := game.ParseInputState(msg.Payload)
+				in.Fire = in.Fire || remoteInput.Fire
+				remoteInput = in
 			}
+		case <-pinger.C:
+			network.SendPing(peer, time.Now())
+		case now := <-animation.C:
+			if ms.Effects.Active(now) {
+				ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, now)
+			}
+		case <-ticker.C:
+			// The tick's events reach the client with the state
+			tickEvents := game.Step(gs, controls.Sample(time.Now()), remoteInput)
+			remoteInput.Fire = false
+			network.SendGameState(peer, gs)
+			bus.Publish(tickEvents)
+			ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, time.Now())
 		}
-	}()
+	}
+	showGameOver(gs, seat, ms, events)
+	return false
+}
+
+// clientGameLoop shows the host's game state and sends our input, and
+// reports whether we quit
+func clientGameLoop(gs *game.GameState, peer *network.Peer, bus *game.EventBus, sound *audio.Player, events <-chan termbox.Event) bool {
+	controls := core.NewControls()
+	seat := game.SeatGuest
+	ms := ui.NewMatchScreen(gs, seat)
+	ms.Muted = sound.Muted()
+	bus.Subscribe(func(ev game.Event) { ms.Effects.Trigger(ev, time.Now()) })
 
-	ticker := time.NewTicker(50 * time.Millisecond)
+	ticker := time.NewTicker(game.TickInterval)
 	defer ticker.Stop()
+	pinger := time.NewTicker(network.PingInterval)
+	defer pinger.Stop()
+	animation := time.NewTicker(ui.AnimationInterval)
+	defer animation.Stop()
 
 	for !gs.IsGameOver {
 		select {
-		case ev := <-playerInput:
-			if ev == "quit" {
+		case ev := <-events:
+			if ev.Type == termbox.EventResize {
+				ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, time.Now())
+			}
+			if handleMatchKey(ev, controls, ms, sound, peer, playerName(gs, seat), time.Now()) {
+				return true
+			}
+		case msg, ok := <-peer.Incoming():
+			if !ok || msg.Type == network.MsgQuit {
 				gs.IsGameOver = true
-				conn.Close()
+				gs.Message = "Opponent left"
 				break
 			}
-			conn.Write([]byte(ev + "\n"))
+			switch msg.Type {
+			case network.MsgState:
+				if err := network.ApplyGameState(gs, msg); err != nil {
+					log.Printf("host: %v", err)
+					break
+				}
+				bus.Publish(gs.Events)
+			case network.MsgChat:
+				receiveChat(ms.Chat, msg, playerName(gs, 1-seat), time.Now())
+			case network.MsgPing, network.MsgPong:
+				ms.RTT = handleLatency(peer, msg, ms.RTT, time.Now())
+			}
+		case <-pinger.C:
+			network.SendPing(peer, time.Now())
+		case now := <-animation.C:
+			if ms.Effects.Active(now) {
+				ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, now)
+			}
 		case <-ticker.C:
-			ui.DrawGame(gs)
+			network.SendInput(peer, controls.Sample(time.Now()))
+			ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, time.Now())
+		}
+	}
+	showGameOver(gs, seat, ms, events)
+	return false
+}
+
+// showGameOver keeps drawing the end of the match, the final explosion and
+// the result, until its effects have played out or a key skips them.
+// Messages from the peer wait for the post-game screen.
+func showGameOver(gs *game.GameState, seat int, ms *ui.MatchScreen, events <-chan termbox.Event) {
+	animation := time.NewTicker(ui.AnimationInterval)
+	defer animation.Stop()
+
+	for now := time.Now(); ms.Effects.Active(now); {
+		select {
+		case ev := <-events:
+			if ev.Type == termbox.EventKey {
+				return
+			}
+			ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, now)
+		case now = <-animation.C:
+			ui.DrawMatch(gs, ui.PlayerView(gs, seat), ms, now)
 		}
 	}
 }
+
+// handleMatchKey routes a key event during a match to the chat box, the
+// mute toggle or the controls, and reports whether the player asked to quit
+func handleMatchKey(ev termbox.Event, controls *core.Controls, ms *ui.MatchScreen, sound *audio.Player, peer *network.Peer, name string, now time.Time) bool {
+	if ev.Type != termbox.EventKey {
+		return false
+	}
+	chat := ms.Chat
+
+	// The open chat box takes every key, including the movement keys
+	if chat.IsOpen() {
+		if text, ok := chat.HandleKey(ev); ok {
+			network.SendChat(peer, text)
+			chat.Add(name, text, true, now)
+		}
+		return false
+	}
+
+	if core.IsQuitKey(ev) {
+		return true
+	}
+	if ev.Ch == core.KeyChat {
+		chat.Open()
+		return false
+	}
+	if ev.Ch == core.KeyMute {
+		ms.Muted = sound.ToggleMute()
+		settings := config.LoadSettings()
+		settings.Sound = !ms.Muted
+		config.SaveSettings(settings)
+		return false
+	}
+	if text, ok := core.QuickMessage(ev); ok {
+		if chat.AllowQuick(text, now) {
+			network.SendChat(peer, text)
+			chat.Add(name, text, true, now)
+		}
+		return false
+	}
+	controls.Press(ev.Ch, now)
+	return false
+}
+
+// receiveChat adds the opponent's chat message to the log
+func receiveChat(chat *ui.Chat, msg network.Message, from string, now time.Time) {
+	text, err := network.DecodeChat(msg)
+	if err != nil || text == "" {
+		return
+	}
+	chat.Add(from, text, false, now)
+}
+
+// handleLatency answers a ping, and returns the round trip time measured by
+// a pong or the previous one
+func handleLatency(peer *network.Peer, msg network.Message, rtt time.Duration, now time.Time) time.Duration {
+	switch msg.Type {
+	case network.MsgPing:
+		network.AnswerPing(peer, msg)
+	case network.MsgPong:
+		if measured, err := network.DecodePong(msg, now); err == nil {
+			return measured
+		}
+	}
+	return rtt
+}
+
+// playerName returns the display name of the player in a seat
+func playerName(gs *game.GameState, seat int) string {
+	if name := gs.Players[seat].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("Player %d", seat+1)
+}
@@ -1,120 +1,162 @@
 package network
 
 import (
-	"bufio"
-	"encoding/json"
+	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
-
-	"shooter-duel/game"
+	"time"
 )
 
 const (
 	Port = "8080"
 )
 
-// RunAsHost runs the game as server (host)
-func RunAsHost(w, h int) (net.Conn, error) {
-	// Get local IP of the host
-	hostIP, err := getLocalIP()
+// Host is a game room bound to a TCP port, waiting for the opponent
+type Host struct {
+	listener *net.TCPListener
+}
+
+// Listen binds a game room to the given address. An empty address listens
+// on Port on all interfaces; on dual-stack systems a single socket then
+// accepts both IPv4 and IPv6 connections.
+func Listen(address string) (*Host, error) {
+	if address == "" {
+		address = ":" + Port
+	}
+	listener, err := net.Listen("tcp", address)
 	if err != nil {
-		hostIP = "localhost"
+		return nil, fmt.Errorf("listen tcp %s: %w", address, err)
 	}
+	return &Host{listener: listener.(*net.TCPListener)}, nil
+}
 
-	// Return IP to be displayed on screen
-	return nil, fmt.Errorf("waiting_for_connection:%s", hostIP)
+// Port returns the TCP port the room is bound to
+func (h *Host) Port() int {
+	return h.listener.Addr().(*net.TCPAddr).Port
 }
 
-// AcceptConnection accepts an incoming connection
-func AcceptConnection() (net.Conn, error) {
-	listener, err := net.Listen("tcp", ":"+Port)
-	if err != nil {
-		return nil, fmt.Errorf("listen tcp :%s: %w", Port, err)
+// Addrs returns the addresses the opponent may use to reach this room, most
+// likely reachable first. It falls back to loopback when no interface has a
+// usable address.
+func (h *Host) Addrs() []LocalAddr {
+	bound := h.listener.Addr().(*net.TCPAddr).IP
+	if !bound.IsUnspecified() {
+		return []LocalAddr{{IP: bound}}
+	}
+
+	addrs, err := LocalAddresses()
+	if err != nil || len(addrs) == 0 {
+		return []LocalAddr{{Interface: "loopback", IP: net.IPv4(127, 0, 0, 1)}}
 	}
-	defer listener.Close()
+	return addrs
+}
 
-	conn, err := listener.Accept()
+// Accept waits for the opponent to connect. It returns ctx.Err() if the
+// context is cancelled first, leaving the room open for another Accept.
+func (h *Host) Accept(ctx context.Context) (net.Conn, error) {
+	// An expired deadline wakes up the blocked Accept call
+	woken := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		h.listener.SetDeadline(time.Unix(1, 0))
+		close(woken)
+	})
+
+	conn, err := h.listener.Accept()
+	if !stop() {
+		// The context was cancelled, maybe only after a connection arrived:
+		// clear the deadline once it is set so later calls can accept
+		<-woken
+		h.listener.SetDeadline(time.Time{})
+	}
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, fmt.Errorf("accept connection: %w", err)
 	}
 	return conn, nil
 }
 
-// getLocalIP gets the local IP of the host
-func getLocalIP() (string, error) {
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return "", err
+// Close stops listening for connections
+func (h *Host) Close() error {
+	return h.listener.Close()
+}
+
+// DefaultHost is used when the player leaves the host address empty
+const DefaultHost = "localhost"
+
+// DialTimeout bounds how long joining a room may take
+const DialTimeout = 5 * time.Second
+
+// ParseHostAddress validates a host address typed by the player and returns
+// it in host:port form. The port defaults to Port when omitted. IPv6
+// literals may be given bare (::1), bracketed ([::1]) or with a port
+// ([::1]:8080), with an optional zone (fe80::1%eth0).
+func ParseHostAddress(input string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return net.JoinHostPort(DefaultHost, Port), nil
+	}
+	if strings.ContainsAny(input, " \t/") {
+		return "", fmt.Errorf("invalid host address %q", input)
 	}
 
-	for _, addr := range addrs {
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP.String(), nil
-			}
+	host, port := input, Port
+	switch {
+	case isIPv6Literal(input):
+		// Bare IPv6 literal, its colons are not a port separator
+	case strings.HasPrefix(input, "[") && strings.HasSuffix(input, "]"):
+		host = input[1 : len(input)-1]
+		if !isIPv6Literal(host) {
+			return "", fmt.Errorf("invalid IPv6 address %q", host)
+		}
+	case strings.Contains(input, ":"):
+		var err error
+		host, port, err = net.SplitHostPort(input)
+		if err != nil {
+			return "", fmt.Errorf("invalid host address %q", input)
+		}
+		if strings.HasPrefix(input, "[") && !isIPv6Literal(host) {
+			return "", fmt.Errorf("invalid IPv6 address %q", host)
 		}
 	}
-	return "", fmt.Errorf("no local IP found")
-}
-
-// RunAsClient runs the game as client
-func RunAsClient(w, h int) (net.Conn, error) {
-	fmt.Print("Enter host IP (default: localhost): ")
-	var hostIP string
-	fmt.Scanln(&hostIP)
-	if hostIP == "" {
-		hostIP = "localhost"
+	if host == "" {
+		return "", fmt.Errorf("missing host in %q", input)
 	}
 
-	conn, err := net.Dial("tcp", hostIP+":"+Port)
-	if err != nil {
-		return nil, fmt.Errorf("dial tcp %s:%s: %w", hostIP, Port, err)
+	portNum, err := strconv.Atoi(port)
+	if err != nil || portNum < 1 || portNum > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
 	}
-
-	return conn, nil
+	return net.JoinHostPort(host, port), nil
 }
 
-// SendGameState sends the game state through the connection
-func SendGameState(conn net.Conn, gs *game.GameState) {
-	data, err := json.Marshal(gs)
-	if err != nil {
-		return
+// isIPv6Literal reports whether s is an IPv6 address with an optional zone
+func isIPv6Literal(s string) bool {
+	if i := strings.LastIndex(s, "%"); i > 0 {
+		s = s[:i]
 	}
-	conn.Write(data)
-	conn.Write([]byte("\n"))
+	ip := net.ParseIP(s)
+	return ip != nil && ip.To4() == nil
 }
 
-// ReadInputFromNetwork reads player input from the network
-func ReadInputFromNetwork(conn net.Conn, inputChan chan string) {
-	reader := bufio.NewReader(conn)
-	for {
-		input, err := reader.ReadString('\n')
-		if err != nil {
-			inputChan <- "quit"
-			return
-		}
-		inputChan <- strings.TrimSpace(input)
+// hostString formats an IP for use with net.JoinHostPort, keeping the zone
+// that link-local IPv6 addresses need to be dialled
+func hostString(ip net.IP, zone string) string {
+	if zone != "" && ip.To4() == nil {
+		return ip.String() + "%" + zone
 	}
+	return ip.String()
 }
 
-// ReadGameStateFromNetwork reads the game state from the network
-func ReadGameStateFromNetwork(conn net.Conn, gs *game.GameState) error {
-	reader := bufio.NewReader(conn)
-	jsonState, err := reader.ReadString('\n')
+// RunAsClient connects to the host at the given address
+func RunAsClient(address string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", address, DialTimeout)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("dial tcp %s: %w", address, err)
 	}
 
-	var receivedState game.GameState
-	if err := json.Unmarshal([]byte(jsonState), &receivedState); err != nil {
-		return err
-	}
-
-	gs.Players = receivedState.Players
-	gs.Bullets = receivedState.Bullets
-	gs.IsGameOver = receivedState.IsGameOver
-	gs.Winner = receivedState.Winner
-
-	return nil
+	return conn, nil
 }
@@ -0,0 +1,299 @@
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DiscoveryPort is the UDP port rooms are announced on
+	DiscoveryPort = "8081"
+	// ProtocolVersion is bumped whenever the game protocol changes incompatibly
+	ProtocolVersion = 7
+	// AnnounceInterval is how often an open room announces itself
+	AnnounceInterval = time.Second
+	// RoomTimeout is how long a room stays listed after its last announcement
+	RoomTimeout = 3 * AnnounceInterval
+
+	discoveryMagic = "shooter-duel"
+	maxPacketSize  = 1024
+)
+
+// RoomAnnouncement describes an open room on the local network
+type RoomAnnouncement struct {
+	Magic      string
+	Version    int
+	Name       string
+	Players    int
+	MaxPlayers int
+	Port       int
+	TLS        bool
+	Locked     bool // a password is required to join
+}
+
+// DiscoveredRoom is a room heard from on the local network
+type DiscoveredRoom struct {
+	RoomAnnouncement
+	Address  string // host:port to join
+	LastSeen time.Time
+}
+
+// Compatible reports whether the room speaks our protocol version
+func (r DiscoveredRoom) Compatible() bool {
+	return r.Version == ProtocolVersion
+}
+
+// =============================================================================
+// ANNOUNCER
+// =============================================================================
+
+// listenUDP opens the announcer's sockets; tests replace it
+var listenUDP = net.ListenUDP
+
+// Announcer periodically broadcasts a room announcement
+type Announcer struct {
+	conn4   *net.UDPConn // nil when there are no IPv4 targets
+	conn6   *net.UDPConn // nil when there are no IPv6 targets
+	targets []*net.UDPAddr
+	room    RoomAnnouncement
+}
+
+// NewAnnouncer prepares to announce a room to the given UDP addresses
+func NewAnnouncer(name string, port int, opts HostOptions, targets []string) (*Announcer, error) {
+	addrs := []*net.UDPAddr{}
+	for _, target := range targets {
+		addr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			return nil, fmt.Errorf("resolve udp %s: %w", target, err)
+		}
+		addrs = append(addrs, addr)
+	}
+
+	// Broadcast needs an IPv4 socket, so each family gets its own. Without
+	// IPv6 the room is only announced over IPv4.
+	a := &Announcer{}
+	ipv6 := true
+	for _, addr := range addrs {
+		if addr.IP.To4() == nil {
+			if !ipv6 {
+				continue
+			}
+			if a.conn6 == nil {
+				conn, err := listenUDP("udp6", nil)
+				if err != nil {
+					log.Printf("discovery: announcing over IPv4 only: listen udp6: %v", err)
+					ipv6 = false
+					continue
+				}
+				a.conn6 = conn
+			}
+		} else if a.conn4 == nil {
+			conn, err := listenUDP("udp4", nil)
+			if err != nil {
+				a.close()
+				return nil, fmt.Errorf("listen udp: %w", err)
+			}
+			a.conn4 = conn
+		}
+		a.targets = append(a.targets, addr)
+	}
+
+	a.room = RoomAnnouncement{
+		Magic:      discoveryMagic,
+		Version:    ProtocolVersion,
+		Name:       name,
+		Players:    1,
+		MaxPlayers: 2,
+		Port:       port,
+		TLS:        opts.TLS,
+		Locked:     opts.Password != "",
+	}
+	return a, nil
+}
+
+// close releases the announcer's sockets
+func (a *Announcer) close() {
+	if a.conn4 != nil {
+		a.conn4.Close()
+	}
+	if a.conn6 != nil {
+		a.conn6.Close()
+	}
+}
+
+// Run announces the room every AnnounceInterval until the context is done,
+// then closes the announcer
+func (a *Announcer) Run(ctx context.Context) {
+	defer a.close()
+
+	ticker := time.NewTicker(AnnounceInterval)
+	defer ticker.Stop()
+
+	for {
+		a.announce()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// announce sends one announcement to every target; send errors are ignored
+// since some broadcast addresses may be unreachable
+func (a *Announcer) announce() {
+	data, err := json.Marshal(a.room)
+	if err != nil {
+		return
+	}
+	for _, target := range a.targets {
+		if target.IP.To4() != nil {
+			a.conn4.WriteToUDP(data, target)
+		} else {
+			a.conn6.WriteToUDP(data, target)
+		}
+	}
+}
+
+// BroadcastTargets returns the limited broadcast address, the directed
+// broadcast address of every IPv4 interface and the IPv6 all-nodes multicast
+// group on every IPv6 interface, all on DiscoveryPort
+func BroadcastTargets() []string {
+	targets := []string{net.JoinHostPort("255.255.255.255", DiscoveryPort)}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return targets
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		hasIPv6 := false
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipnet.IP.To4()
+			if ip == nil {
+				hasIPv6 = true
+				continue
+			}
+			if iface.Flags&net.FlagBroadcast == 0 || len(ipnet.Mask) != net.IPv4len {
+				continue
+			}
+			bcast := make(net.IP, net.IPv4len)
+			for i := range bcast {
+				bcast[i] = ip[i] | ^ipnet.Mask[i]
+			}
+			targets = append(targets, net.JoinHostPort(bcast.String(), DiscoveryPort))
+		}
+		if hasIPv6 && iface.Flags&net.FlagMulticast != 0 {
+			targets = append(targets, net.JoinHostPort("ff02::1%"+iface.Name, DiscoveryPort))
+		}
+	}
+	return targets
+}
+
+// =============================================================================
+// BROWSER
+// =============================================================================
+
+// Browser listens for room announcements and keeps a list of live rooms
+type Browser struct {
+	conn *net.UDPConn
+
+	mu    sync.Mutex
+	rooms map[string]*DiscoveredRoom
+}
+
+// ListenForRooms starts listening for announcements on the given UDP
+// address. An empty address listens on DiscoveryPort on all IPv4 and IPv6
+// interfaces.
+func ListenForRooms(address string) (*Browser, error) {
+	if address == "" {
+		address = ":" + DiscoveryPort
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("resolve udp %s: %w", address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen udp %s: %w", address, err)
+	}
+	return &Browser{conn: conn, rooms: make(map[string]*DiscoveredRoom)}, nil
+}
+
+// LocalAddr returns the UDP address the browser is listening on
+func (b *Browser) LocalAddr() *net.UDPAddr {
+	return b.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Run reads announcements until the browser is closed
+func (b *Browser) Run() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b.handlePacket(buf[:n], from, time.Now())
+	}
+}
+
+// handlePacket records a valid announcement; anything else is ignored
+func (b *Browser) handlePacket(data []byte, from *net.UDPAddr, now time.Time) {
+	var room RoomAnnouncement
+	if err := json.Unmarshal(data, &room); err != nil || room.Magic != discoveryMagic {
+		return
+	}
+	if room.Port < 1 || room.Port > 65535 {
+		return
+	}
+
+	address := net.JoinHostPort(hostString(from.IP, from.Zone), strconv.Itoa(room.Port))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rooms[address] = &DiscoveredRoom{RoomAnnouncement: room, Address: address, LastSeen: now}
+}
+
+// Rooms returns the rooms heard from within RoomTimeout, sorted by name
+func (b *Browser) Rooms(now time.Time) []DiscoveredRoom {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	rooms := []DiscoveredRoom{}
+	for address, room := range b.rooms {
+		if now.Sub(room.LastSeen) > RoomTimeout {
+			delete(b.rooms, address)
+			continue
+		}
+		rooms = append(rooms, *room)
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Name != rooms[j].Name {
+			return rooms[i].Name < rooms[j].Name
+		}
+		return rooms[i].Address < rooms[j].Address
+	})
+	return rooms
+}
+
+// Close stops listening for announcements
+func (b *Browser) Close() error {
+	return b.conn.Close()
+}
@@ -0,0 +1,223 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"shooter-duel/game"
+)
+
+const (
+	// InputBudget is how many input messages a client may send per tick on
+	// average; honest clients send exactly one
+	InputBudget = 1
+	// InputBurst is how many input messages may arrive back to back, to
+	// absorb network jitter bunching several ticks together
+	InputBurst = 5
+	// MaxInputStall is the longest network stall after which the bunched-up
+	// inputs are dropped without counting as violations. Only clients that
+	// send more inputs than ticks passed, beyond that, are flooding.
+	MaxInputStall = 5 * time.Second
+	// MessageBudget is how many other messages a client may send per second
+	MessageBudget = 5
+	// MessageBurst is how many other messages may arrive back to back
+	MessageBurst = 10
+	// MaxMessageRate is how many other messages per second are flooding
+	// rather than a held key repeating, which sends about 30 a second.
+	// Messages over the budget but below this rate are dropped without
+	// counting as violations.
+	MaxMessageRate = 100
+	// MaxViolations is how many invalid messages are tolerated before the
+	// client is kicked
+	MaxViolations = 20
+	// MaxInputLine bounds the payload of one input message
+	MaxInputLine = 64
+)
+
+// ErrClientKicked is reported when a client is disconnected for misbehaving
+var ErrClientKicked = errors.New("client kicked")
+
+// Violation is a kind of invalid message from the client
+type Violation int
+
+// Violation kinds
+const (
+	ViolationUnknownAction Violation = iota
+	ViolationUnknownMessage
+	ViolationOverBudget
+	ViolationOversized
+	violationKinds
+)
+
+func (v Violation) String() string {
+	switch v {
+	case ViolationUnknownAction:
+		return "unknown action"
+	case ViolationUnknownMessage:
+		return "unknown message type"
+	case ViolationOverBudget:
+		return "over input budget"
+	case ViolationOversized:
+		return "oversized message"
+	}
+	return "unknown violation"
+}
+
+// tokenBucket allows rate tokens per second on average, up to burst at once
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// take consumes a token if one is available at the given time
+func (b *tokenBucket) take(now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = b.burst
+		b.last = now
+	}
+	// Messages handled out of order must not drain the bucket
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// InputGuard validates the client's messages on the host: only known
+// message types and input actions are accepted, each client gets a per-tick
+// input budget, and clients exceeding MaxViolations are kicked. Input over
+// the budget is dropped; it is a violation only when the client sent more
+// inputs than ticks passed, which no network stall explains. Other messages
+// over their budget count only at rates no held key reaches.
+type InputGuard struct {
+	Peer          string
+	MaxViolations int
+	Logger        *log.Logger
+
+	mu       sync.Mutex
+	input    tokenBucket
+	stall    tokenBucket
+	messages tokenBucket
+	flood    tokenBucket
+	counts   [violationKinds]int
+	total    int
+	kicked   error
+}
+
+// NewInputGuard creates a guard with the default budgets and threshold
+func NewInputGuard(peer string) *InputGuard {
+	return &InputGuard{
+		Peer:          peer,
+		MaxViolations: MaxViolations,
+		Logger:        log.Default(),
+		input: tokenBucket{
+			rate:  InputBudget / game.TickInterval.Seconds(),
+			burst: InputBurst,
+		},
+		stall: tokenBucket{
+			rate:  InputBudget / game.TickInterval.Seconds(),
+			burst: float64(MaxInputStall / game.TickInterval),
+		},
+		messages: tokenBucket{rate: MessageBudget, burst: MessageBurst},
+		flood:    tokenBucket{rate: MaxMessageRate, burst: MaxMessageRate},
+	}
+}
+
+// CheckMessage validates one message received at the given time; truncated
+// reports that the line was cut off at the peer's line limit. It returns
+// false if the message must be dropped, and an error once the client has
+// exceeded the violation threshold and must be kicked.
+func (g *InputGuard) CheckMessage(msg Message, truncated bool, now time.Time) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.kicked != nil {
+		return false, g.kicked
+	}
+	if truncated {
+		return false, g.violation(ViolationOversized, msg.Type)
+	}
+	if !clientMessages[msg.Type] {
+		return false, g.violation(ViolationUnknownMessage, msg.Type)
+	}
+
+	switch msg.Type {
+	case MsgQuit:
+		return true, nil
+	case MsgInput:
+		if len(msg.Payload) > MaxInputLine {
+			return false, g.violation(ViolationOversized, fmt.Sprintf("%d bytes", len(msg.Payload)))
+		}
+		sustained := g.stall.take(now)
+		if !g.input.take(now) {
+			if sustained {
+				// inputs bunched up by a stall; the game only misses them
+				return false, nil
+			}
+			return false, g.violation(ViolationOverBudget, msg.Payload)
+		}
+		if _, err := game.ParseInputState(msg.Payload); err != nil {
+			return false, g.violation(ViolationUnknownAction, err.Error())
+		}
+	default:
+		sustained := g.flood.take(now)
+		if !g.messages.take(now) {
+			if sustained {
+				// a held key repeating; the extra messages are just dropped
+				return false, nil
+			}
+			return false, g.violation(ViolationOverBudget, msg.Type)
+		}
+	}
+	return true, nil
+}
+
+// violation logs and counts one violation, kicking the client past the threshold
+func (g *InputGuard) violation(v Violation, detail string) error {
+	g.counts[v]++
+	g.total++
+	if g.Logger != nil {
+		g.Logger.Printf("input violation from %s: %s: %q (%d/%d)", g.Peer, v, detail, g.total, g.MaxViolations)
+	}
+	if g.total > g.MaxViolations {
+		g.kicked = fmt.Errorf("%w: %d input violations", ErrClientKicked, g.total)
+		if g.Logger != nil {
+			g.Logger.Printf("kicking %s: %v", g.Peer, g.kicked)
+		}
+		return g.kicked
+	}
+	return nil
+}
+
+// Violations returns how many violations of a kind were seen
+func (g *InputGuard) Violations(v Violation) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.counts[v]
+}
+
+// TotalViolations returns how many violations were seen in total
+func (g *InputGuard) TotalViolations() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.total
+}
+
+// Err returns the reason the client was kicked, or nil
+func (g *InputGuard) Err() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.kicked
+}
@@ -0,0 +1,344 @@
+package network
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"shooter-duel/game"
+)
+
+const (
+	// HandshakeTimeout bounds how long the connection handshake may take
+	HandshakeTimeout = 10 * time.Second
+	// PasswordTimeout bounds how long the joining player may take to type
+	// the room password
+	PasswordTimeout = 2 * time.Minute
+	// FailedAuthDelay slows down password guessing
+	FailedAuthDelay = time.Second
+
+	// maxHandshakeLine bounds a single handshake message
+	maxHandshakeLine = 4096
+	challengeSize    = 32
+	authContext      = "shooter-duel room password v1"
+)
+
+// Handshake errors reported to the joining player
+var (
+	ErrWrongPassword = errors.New("wrong password")
+	ErrJoinCancelled = errors.New("join cancelled")
+)
+
+// Hello is the first message the host sends to a joining client
+type Hello struct {
+	Version          int
+	TLS              bool
+	PasswordRequired bool
+	Challenge        []byte     // random nonce the client proves the password against
+	Arena            game.Arena // size of the playing field picked by the host
+}
+
+// AuthResponse proves knowledge of the room password without sending it
+type AuthResponse struct {
+	Cancelled bool
+	MAC       []byte
+}
+
+// AuthResult tells the client whether it was admitted
+type AuthResult struct {
+	OK bool
+}
+
+// HostOptions controls the host side of the connection handshake
+type HostOptions struct {
+	TLS         bool
+	Certificate tls.Certificate
+	Password    string     // empty for an open room
+	Arena       game.Arena // zero for game.DefaultArena
+}
+
+// JoinOptions controls the client side of the connection handshake
+type JoinOptions struct {
+	KnownHosts *KnownHosts
+	// Password asks the player for the room password when the host requires
+	// one; returning false cancels joining
+	Password func() (string, bool)
+}
+
+// Session is an established connection to the other player
+type Session struct {
+	Conn        net.Conn
+	TLS         bool
+	Fingerprint string // host certificate fingerprint when TLS is used
+	NewHost     bool   // the fingerprint was pinned during this handshake
+	Arena       game.Arena
+}
+
+// ServerHandshake greets a newly accepted client and upgrades the connection
+// to TLS when enabled
+func ServerHandshake(conn net.Conn, opts HostOptions) (*Session, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	hello := Hello{Version: ProtocolVersion, TLS: opts.TLS, Arena: opts.Arena}
+	if hello.Arena == (game.Arena{}) {
+		hello.Arena = game.DefaultArena
+	}
+	if opts.Password != "" {
+		hello.PasswordRequired = true
+		hello.Challenge = make([]byte, challengeSize)
+		if _, err := rand.Read(hello.Challenge); err != nil {
+			return nil, fmt.Errorf("generate challenge: %w", err)
+		}
+	}
+	if err := writeMessage(conn, hello); err != nil {
+		return nil, fmt.Errorf("send hello: %w", err)
+	}
+
+	session := &Session{Conn: conn, Arena: hello.Arena}
+	if opts.TLS {
+		tlsConn := tls.Server(conn, &tls.Config{
+			Certificates: []tls.Certificate{opts.Certificate},
+			MinVersion:   tls.VersionTLS13,
+		})
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("tls handshake: %w", err)
+		}
+		session.Conn = tlsConn
+		session.TLS = true
+		session.Fingerprint = CertificateFingerprint(opts.Certificate)
+	}
+
+	if hello.PasswordRequired {
+		if err := verifyPassword(session.Conn, opts.Password, hello.Challenge); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// AcceptSession waits for a client to join the room. Each connection is
+// greeted in its own goroutine, so a client sitting at the password prompt
+// doesn't hold up others; the first to complete the handshake joins and
+// the rest are dropped. It returns ctx.Err() if the context is cancelled
+// first.
+func (h *Host) AcceptSession(ctx context.Context, opts HostOptions) (*Session, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sessions := make(chan *Session)
+	failed := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := h.Accept(ctx)
+			if err != nil {
+				failed <- err
+				return
+			}
+			go func() {
+				// Half-joined clients are dropped once a session is chosen
+				stop := context.AfterFunc(ctx, func() { conn.Close() })
+				session, err := ServerHandshake(conn, opts)
+				if !stop() {
+					return
+				}
+				if err != nil {
+					conn.Close()
+					return
+				}
+				select {
+				case sessions <- session:
+				case <-ctx.Done():
+					session.Conn.Close()
+				}
+			}()
+		}
+	}()
+
+	select {
+	case session := <-sessions:
+		return session, nil
+	case err := <-failed:
+		return nil, err
+	}
+}
+
+// verifyPassword checks the client's proof of the room password and tells
+// it whether it was admitted
+func verifyPassword(conn net.Conn, password string, challenge []byte) error {
+	conn.SetDeadline(time.Now().Add(PasswordTimeout))
+
+	var response AuthResponse
+	if err := readMessage(conn, &response); err != nil {
+		return fmt.Errorf("read auth response: %w", err)
+	}
+	if response.Cancelled {
+		return ErrJoinCancelled
+	}
+
+	ok := hmac.Equal(response.MAC, passwordMAC(password, challenge))
+	if !ok {
+		time.Sleep(FailedAuthDelay)
+	}
+	if err := writeMessage(conn, AuthResult{OK: ok}); err != nil {
+		return fmt.Errorf("send auth result: %w", err)
+	}
+	if !ok {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// passwordMAC binds the room password to the host's challenge
+func passwordMAC(password string, challenge []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(authContext))
+	mac.Write(challenge)
+	return mac.Sum(nil)
+}
+
+// ClientHandshake reads the host's greeting and, when the host offers TLS,
+// upgrades the connection and checks the certificate against the fingerprint
+// pinned for the address. Hosts seen for the first time are pinned. Rooms
+// with a password are joined by answering the host's challenge.
+func ClientHandshake(conn net.Conn, address string, opts JoinOptions) (*Session, error) {
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var hello Hello
+	if err := readMessage(conn, &hello); err != nil {
+		return nil, fmt.Errorf("read hello: %w", err)
+	}
+	if hello.Version != ProtocolVersion {
+		return nil, fmt.Errorf("host speaks protocol version %d, we speak %d", hello.Version, ProtocolVersion)
+	}
+	if err := hello.Arena.Validate(); err != nil {
+		return nil, fmt.Errorf("host sent %w", err)
+	}
+
+	session, err := clientUpgrade(conn, address, hello, opts.KnownHosts)
+	if err != nil {
+		return nil, err
+	}
+	session.Arena = hello.Arena
+
+	if hello.PasswordRequired {
+		if err := provePassword(session.Conn, hello.Challenge, opts.Password); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// clientUpgrade switches to TLS when the host offers it, enforcing the
+// pinned fingerprint
+func clientUpgrade(conn net.Conn, address string, hello Hello, knownHosts *KnownHosts) (*Session, error) {
+	known, pinned := knownHosts.Lookup(address)
+	if !hello.TLS {
+		// A pinned host dropping TLS may be someone stripping encryption
+		if pinned {
+			return nil, &FingerprintMismatchError{Host: address, Known: known}
+		}
+		return &Session{Conn: conn}, nil
+	}
+
+	var presented string
+	tlsConn := tls.Client(conn, &tls.Config{
+		// The certificate is self-signed; trust comes from the pinned fingerprint
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("host sent no certificate")
+			}
+			presented = Fingerprint(rawCerts[0])
+			if pinned && presented != known {
+				return &FingerprintMismatchError{Host: address, Known: known, Presented: presented}
+			}
+			return nil
+		},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		var mismatch *FingerprintMismatchError
+		if errors.As(err, &mismatch) {
+			return nil, mismatch
+		}
+		return nil, fmt.Errorf("tls handshake: %w", err)
+	}
+
+	session := &Session{Conn: tlsConn, TLS: true, Fingerprint: presented}
+	if !pinned {
+		if err := knownHosts.Add(address, presented); err != nil {
+			return nil, fmt.Errorf("save known host: %w", err)
+		}
+		session.NewHost = true
+	}
+	return session, nil
+}
+
+// provePassword asks the player for the room password and answers the
+// host's challenge with it
+func provePassword(conn net.Conn, challenge []byte, ask func() (string, bool)) error {
+	// The host waits while the player types, so our deadline must too
+	conn.SetDeadline(time.Time{})
+	password, ok := "", false
+	if ask != nil {
+		password, ok = ask()
+	}
+	conn.SetDeadline(time.Now().Add(HandshakeTimeout + FailedAuthDelay))
+
+	if !ok {
+		writeMessage(conn, AuthResponse{Cancelled: true})
+		return ErrJoinCancelled
+	}
+	if err := writeMessage(conn, AuthResponse{MAC: passwordMAC(password, challenge)}); err != nil {
+		return fmt.Errorf("send auth response: %w", err)
+	}
+
+	var result AuthResult
+	if err := readMessage(conn, &result); err != nil {
+		return fmt.Errorf("read auth result: %w", err)
+	}
+	if !result.OK {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// writeMessage sends a handshake message as one JSON line
+func writeMessage(conn net.Conn, msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(data, '\n'))
+	return err
+}
+
+// readMessage reads one JSON line without buffering past the newline, so
+// nothing meant for a later reader (or the TLS layer) is consumed
+func readMessage(conn net.Conn, msg interface{}) error {
+	line := []byte{}
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			return err
+		}
+		if buf[0] == '\n' {
+			break
+		}
+		if len(line) >= maxHandshakeLine {
+			return errors.New("handshake message too long")
+		}
+		line = append(line, buf[0])
+	}
+	return json.Unmarshal(line, msg)
+}
@@ -0,0 +1,91 @@
+package network
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+// LocalAddr is a candidate address the opponent may use to reach this host
+type LocalAddr struct {
+	Interface string
+	IP        net.IP
+	Virtual   bool // likely a container bridge, VM or VPN interface
+}
+
+// virtualPrefixes are interface name prefixes used by container runtimes,
+// hypervisors and VPN clients
+var virtualPrefixes = []string{
+	"docker", "br-", "veth", "virbr", "vmnet", "vboxnet", "vnic",
+	"tun", "tap", "wg", "utun", "zt", "tailscale", "cni", "flannel",
+	"podman", "lxc", "lxd", "vEthernet",
+}
+
+// isVirtualInterface guesses whether an interface is unlikely to be reachable
+// from another machine on the LAN
+func isVirtualInterface(iface net.Interface) bool {
+	if iface.Flags&net.FlagPointToPoint != 0 {
+		return true
+	}
+	name := strings.ToLower(iface.Name)
+	for _, prefix := range virtualPrefixes {
+		if strings.HasPrefix(name, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// usableIP reports whether an interface address can be shared with the opponent
+func usableIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsMulticast() &&
+		!ip.IsLinkLocalUnicast()
+}
+
+// LocalAddresses lists every usable address of the interfaces that are up,
+// physical interfaces first and IPv4 before IPv6
+func LocalAddresses() ([]LocalAddr, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []LocalAddr{}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		result = append(result, interfaceAddresses(iface, addrs)...)
+	}
+	sortLocalAddrs(result)
+	return result, nil
+}
+
+// interfaceAddresses converts the addresses of one interface into candidates
+func interfaceAddresses(iface net.Interface, addrs []net.Addr) []LocalAddr {
+	virtual := isVirtualInterface(iface)
+	result := []LocalAddr{}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || !usableIP(ipnet.IP) {
+			continue
+		}
+		result = append(result, LocalAddr{Interface: iface.Name, IP: ipnet.IP, Virtual: virtual})
+	}
+	return result
+}
+
+// sortLocalAddrs orders candidates so the most likely reachable come first
+func sortLocalAddrs(addrs []LocalAddr) {
+	sort.SliceStable(addrs, func(i, j int) bool {
+		if addrs[i].Virtual != addrs[j].Virtual {
+			return !addrs[i].Virtual
+		}
+		iv4, jv4 := addrs[i].IP.To4() != nil, addrs[j].IP.To4() != nil
+		return iv4 && !jv4
+	})
+}
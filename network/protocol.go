@@ -0,0 +1,308 @@
+package network
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"shooter-duel/game"
+)
+
+// Message types exchanged once the handshake is done. Each message is one
+// line: the type, a space and the payload. Input payloads are the action
+// list of game.InputState; all other payloads are JSON.
+const (
+	MsgInput = "input" // client → host: held controls for one tick
+	MsgState = "state" // host → client: game state snapshot
+	MsgQuit  = "quit"  // either way: the player left
+
+	MsgLobby = "lobby" // host → client: lobby snapshot
+	MsgName  = "name"  // client → host: display name
+	MsgReady = "ready" // client → host: ready flag
+	MsgShip  = "ship"  // client → host: the ship picked, with its art
+	MsgStart = "start" // host → client: match begins, with the settings and ships
+
+	MsgChat    = "chat"    // either way: chat message
+	MsgRematch = "rematch" // either way: rematch vote after a match
+
+	MsgPing = "ping" // either way: round trip time probe
+	MsgPong = "pong" // either way: answer to a ping
+)
+
+// clientMessages are the message types a client may send to the host
+var clientMessages = map[string]bool{
+	MsgInput:   true,
+	MsgQuit:    true,
+	MsgName:    true,
+	MsgReady:   true,
+	MsgShip:    true,
+	MsgChat:    true,
+	MsgRematch: true,
+	MsgPing:    true,
+	MsgPong:    true,
+}
+
+const (
+	// MaxClientLine bounds a message sent by the client; the largest is a
+	// ship's art
+	MaxClientLine = 2048
+	// MaxHostLine bounds a message sent by the host
+	MaxHostLine = 1 << 20
+)
+
+// Message is one typed message received from the other player
+type Message struct {
+	Type    string
+	Payload string
+}
+
+// Decode unmarshals a JSON payload
+func (m Message) Decode(v interface{}) error {
+	if err := json.Unmarshal([]byte(m.Payload), v); err != nil {
+		return fmt.Errorf("decode %s message: %w", m.Type, err)
+	}
+	return nil
+}
+
+// Peer sends and receives typed messages over an established connection.
+// A single goroutine reads the connection for the whole session, so no
+// buffered data is lost between the lobby, the match and what follows.
+type Peer struct {
+	conn     net.Conn
+	guard    *InputGuard
+	maxLine  int
+	incoming chan Message
+	done     chan struct{}
+	once     sync.Once
+
+	mu sync.Mutex // serializes writes
+}
+
+// NewHostPeer wraps the host's connection to the client. Everything the
+// client sends is checked by the guard.
+func NewHostPeer(conn net.Conn, guard *InputGuard) *Peer {
+	return newPeer(conn, guard, MaxClientLine)
+}
+
+// NewClientPeer wraps the client's connection to the host
+func NewClientPeer(conn net.Conn) *Peer {
+	return newPeer(conn, nil, MaxHostLine)
+}
+
+func newPeer(conn net.Conn, guard *InputGuard, maxLine int) *Peer {
+	p := &Peer{
+		conn:     conn,
+		guard:    guard,
+		maxLine:  maxLine,
+		incoming: make(chan Message, 16),
+		done:     make(chan struct{}),
+	}
+	go p.readLoop()
+	return p
+}
+
+// Incoming returns the received messages. The channel is closed when the
+// connection fails, the other player quits or the guard kicks the client.
+func (p *Peer) Incoming() <-chan Message {
+	return p.incoming
+}
+
+// readLoop reads messages until the connection fails
+func (p *Peer) readLoop() {
+	defer close(p.incoming)
+	reader := bufio.NewReaderSize(p.conn, p.maxLine)
+	for {
+		line, err := readBoundedLine(reader)
+		if err != nil {
+			return
+		}
+		truncated := !strings.HasSuffix(line, "\n")
+		msgType, payload, _ := strings.Cut(strings.TrimSpace(line), " ")
+		msg := Message{Type: msgType, Payload: payload}
+
+		if p.guard != nil {
+			ok, err := p.guard.CheckMessage(msg, truncated, time.Now())
+			if err != nil {
+				p.conn.Close()
+				return
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		select {
+		case p.incoming <- msg:
+		case <-p.done:
+			return
+		}
+		if msg.Type == MsgQuit {
+			return
+		}
+	}
+}
+
+// readBoundedLine reads one line without growing past the reader's buffer.
+// Longer lines are discarded up to the newline and only their start is
+// returned, so a peer can't make us buffer unbounded data.
+func readBoundedLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return string(line), err
+	}
+	prefix := string(line)
+	for err == bufio.ErrBufferFull {
+		_, err = reader.ReadSlice('\n')
+	}
+	return prefix, err
+}
+
+// SendRaw sends a message with a preformatted payload
+func (p *Peer) SendRaw(msgType, payload string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.conn.Write([]byte(msgType + " " + payload + "\n"))
+	return err
+}
+
+// Send sends a message with a JSON payload
+func (p *Peer) Send(msgType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return p.SendRaw(msgType, string(data))
+}
+
+// Close tells the other player we are leaving and closes the connection
+func (p *Peer) Close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		p.SendRaw(MsgQuit, "")
+		err = p.conn.Close()
+	})
+	return err
+}
+
+// RemoteAddr returns the other player's network address
+func (p *Peer) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// =============================================================================
+// GAME MESSAGES
+// =============================================================================
+
+// SendInput sends one tick of held input to the host
+func SendInput(p *Peer, in game.InputState) error {
+	return p.SendRaw(MsgInput, in.String())
+}
+
+// SendGameState sends a game state snapshot to the client
+func SendGameState(p *Peer, gs *game.GameState) error {
+	return p.Send(MsgState, gs)
+}
+
+// ApplyGameState copies a received game state snapshot into the client's
+// state. Snapshots that don't fit the match are refused and leave the state
+// as it was.
+func ApplyGameState(gs *game.GameState, msg Message) error {
+	var receivedState game.GameState
+	if err := msg.Decode(&receivedState); err != nil {
+		return err
+	}
+	if err := checkSnapshot(gs, &receivedState); err != nil {
+		return fmt.Errorf("invalid game state: %w", err)
+	}
+
+	gs.Players = receivedState.Players
+	gs.Bullets = receivedState.Bullets
+	gs.IsGameOver = receivedState.IsGameOver
+	gs.Winner = receivedState.Winner
+	gs.Message = receivedState.Message
+	gs.Settings = receivedState.Settings
+	gs.Obstacles = receivedState.Obstacles
+	gs.Round = receivedState.Round
+	gs.RoundOver = receivedState.RoundOver
+	gs.Elapsed = receivedState.Elapsed
+	gs.Events = receivedState.Events
+
+	return nil
+}
+
+// checkSnapshot rejects a snapshot with other players than the match
+// started with, missing entities or settings outside the lobby's choices
+func checkSnapshot(gs, received *game.GameState) error {
+	if len(received.Players) != len(gs.Players) {
+		return fmt.Errorf("expected %d players, got %d", len(gs.Players), len(received.Players))
+	}
+	for i, p := range received.Players {
+		if p == nil {
+			return fmt.Errorf("player %d is missing", i+1)
+		}
+		if p.ID != gs.Players[i].ID {
+			return fmt.Errorf("expected player %d, got ID %d", gs.Players[i].ID, p.ID)
+		}
+	}
+	for _, b := range received.Bullets {
+		if b == nil {
+			return errors.New("bullet is missing")
+		}
+	}
+	return received.Settings.Validate()
+}
+
+// SendChat sends a chat message to the other player
+func SendChat(p *Peer, text string) error {
+	return p.Send(MsgChat, game.SanitizeText(text, game.MaxChatLength))
+}
+
+// DecodeChat returns the text of a received chat message, cleaned up so it
+// is safe to draw
+func DecodeChat(msg Message) (string, error) {
+	var text string
+	if err := msg.Decode(&text); err != nil {
+		return "", err
+	}
+	return game.SanitizeText(text, game.MaxChatLength), nil
+}
+
+// =============================================================================
+// LATENCY
+// =============================================================================
+
+// PingInterval is how often each player measures the round trip time
+const PingInterval = time.Second
+
+// SendPing sends a ping stamped with the given time
+func SendPing(p *Peer, now time.Time) error {
+	return p.Send(MsgPing, now.UnixNano())
+}
+
+// AnswerPing sends the stamp of a received ping back as a pong
+func AnswerPing(p *Peer, msg Message) error {
+	var stamp int64
+	if err := msg.Decode(&stamp); err != nil {
+		return err
+	}
+	return p.Send(MsgPong, stamp)
+}
+
+// DecodePong returns the round trip time of a pong received at the given time
+func DecodePong(msg Message, now time.Time) (time.Duration, error) {
+	var stamp int64
+	if err := msg.Decode(&stamp); err != nil {
+		return 0, err
+	}
+	rtt := now.Sub(time.Unix(0, stamp))
+	if rtt < 0 {
+		return 0, fmt.Errorf("pong stamped in the future")
+	}
+	return rtt, nil
+}
@@ -0,0 +1,184 @@
+package network
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CertificateLifetime is how long a generated host certificate stays valid
+const CertificateLifetime = 10 * 365 * 24 * time.Hour
+
+// FingerprintMismatchError is returned when a host presents a different
+// certificate than the one pinned on first connection
+type FingerprintMismatchError struct {
+	Host      string
+	Known     string
+	Presented string // empty if the host no longer offers TLS
+}
+
+func (e *FingerprintMismatchError) Error() string {
+	if e.Presented == "" {
+		return fmt.Sprintf("host %s used to offer TLS but now connects in plaintext", e.Host)
+	}
+	return fmt.Sprintf("host %s presented fingerprint %s, expected %s", e.Host, e.Presented, e.Known)
+}
+
+// LoadOrCreateCertificate loads the host's TLS certificate from the given PEM
+// files, generating a self-signed one on first run
+func LoadOrCreateCertificate(certPath, keyPath string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		return cert, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return tls.Certificate{}, fmt.Errorf("load certificate: %w", err)
+	}
+
+	certPEM, keyPEM, err := generateCertificate()
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generate certificate: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyPEM, 0o600); err != nil {
+		return tls.Certificate{}, err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0o644); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// generateCertificate creates a self-signed ECDSA certificate in PEM form
+func generateCertificate() ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "shooter-duel host"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CertificateLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a DER certificate as
+// lowercase hex in groups of four, short enough for an 80 column screen
+func Fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	digits := hex.EncodeToString(sum[:])
+	groups := []string{}
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// CertificateFingerprint returns the fingerprint of a loaded certificate
+func CertificateFingerprint(cert tls.Certificate) string {
+	if len(cert.Certificate) == 0 {
+		return ""
+	}
+	return Fingerprint(cert.Certificate[0])
+}
+
+// =============================================================================
+// KNOWN HOSTS
+// =============================================================================
+
+// KnownHosts pins the certificate fingerprint of every host joined over TLS
+type KnownHosts struct {
+	path    string
+	entries map[string]string
+}
+
+// LoadKnownHosts reads a known-hosts file with one "host fingerprint" entry
+// per line. A missing file yields an empty list.
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	k := &KnownHosts{path: path, entries: make(map[string]string)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		host, fingerprint, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		k.entries[host] = strings.TrimSpace(fingerprint)
+	}
+	return k, scanner.Err()
+}
+
+// Lookup returns the fingerprint pinned for a host
+func (k *KnownHosts) Lookup(host string) (string, bool) {
+	fingerprint, ok := k.entries[host]
+	return fingerprint, ok
+}
+
+// Add pins a host's fingerprint and saves the file
+func (k *KnownHosts) Add(host, fingerprint string) error {
+	k.entries[host] = fingerprint
+	return k.save()
+}
+
+// save writes the known hosts sorted by host
+func (k *KnownHosts) save() error {
+	hosts := make([]string, 0, len(k.entries))
+	for host := range k.entries {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	var b strings.Builder
+	for _, host := range hosts {
+		fmt.Fprintf(&b, "%s %s\n", host, k.entries[host])
+	}
+	return os.WriteFile(k.path, []byte(b.String()), 0o600)
+}
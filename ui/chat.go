@@ -0,0 +1,137 @@
+package ui
+
+import (
+	"time"
+
+	"shooter-duel/game"
+
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	// ChatVisible is how long a chat line stays on screen
+	ChatVisible = 8 * time.Second
+	// ChatFade is how long before disappearing a chat line starts to fade
+	ChatFade = 3 * time.Second
+	// ChatLines is how many chat lines are shown at once
+	ChatLines = 5
+	// QuickChatCooldown is how soon the same quick message may be sent
+	// again, so a held number key doesn't repeat it
+	QuickChatCooldown = time.Second
+)
+
+// ChatLine is one message in the chat log
+type ChatLine struct {
+	From string
+	Text string
+	At   time.Time
+	Own  bool // sent by us
+}
+
+// Chat is the in-match chat log and, while open, the input box
+type Chat struct {
+	Lines []ChatLine
+	Input *TextInput // nil while the chat box is closed
+
+	quickSent map[string]time.Time // when each quick message was last sent
+}
+
+// NewChat creates an empty chat
+func NewChat() *Chat {
+	return &Chat{}
+}
+
+// Open shows the chat input box
+func (c *Chat) Open() {
+	c.Input = NewTextInput("Say: ", nil)
+	c.Input.MaxLen = game.MaxChatLength
+}
+
+// IsOpen reports whether the chat input box takes the keyboard
+func (c *Chat) IsOpen() bool {
+	return c.Input != nil
+}
+
+// HandleKey applies a key event to the open chat box and returns the
+// message to send when the player presses Enter. Esc closes the box.
+func (c *Chat) HandleKey(ev termbox.Event) (string, bool) {
+	switch c.Input.HandleKey(ev) {
+	case InputSubmit:
+		text := game.SanitizeText(c.Input.Text(), game.MaxChatLength)
+		c.Input = nil
+		return text, text != ""
+	case InputCancel:
+		c.Input = nil
+	}
+	return "", false
+}
+
+// AllowQuick reports whether a quick message may be sent now, and if so
+// starts its cooldown
+func (c *Chat) AllowQuick(text string, now time.Time) bool {
+	if last, ok := c.quickSent[text]; ok && now.Sub(last) < QuickChatCooldown {
+		return false
+	}
+	if c.quickSent == nil {
+		c.quickSent = make(map[string]time.Time)
+	}
+	c.quickSent[text] = now
+	return true
+}
+
+// Add appends a message to the chat log
+func (c *Chat) Add(from, text string, own bool, now time.Time) {
+	c.Lines = append(c.Lines, ChatLine{From: from, Text: text, At: now, Own: own})
+	if len(c.Lines) > ChatLines {
+		c.Lines = c.Lines[len(c.Lines)-ChatLines:]
+	}
+}
+
+// Visible returns the chat lines still on screen, oldest first. While the
+// chat box is open the whole log stays visible.
+func (c *Chat) Visible(now time.Time) []ChatLine {
+	if c.IsOpen() {
+		return c.Lines
+	}
+	visible := []ChatLine{}
+	for _, line := range c.Lines {
+		if now.Sub(line.At) < ChatVisible {
+			visible = append(visible, line)
+		}
+	}
+	return visible
+}
+
+// chatColor fades a chat line from bright to dim before it disappears
+func chatColor(own bool, age time.Duration) Color {
+	if age > ChatVisible-ChatFade {
+		return palette.Faded
+	}
+	if own {
+		return palette.Own | AttrBold
+	}
+	return palette.Opponent | AttrBold
+}
+
+// DrawChat draws the chat log and the open input box over the bottom left
+// of the arena
+func DrawChat(c *Chat, now time.Time, vp Viewport) {
+	y := vp.Height - 1
+	if c.IsOpen() {
+		x, sy := vp.ToScreen(0, y)
+		DrawTextInput(c.Input, x, sy, vp.Width/2)
+		y--
+	}
+
+	lines := c.Visible(now)
+	for i := len(lines) - 1; i >= 0 && y >= 0; i-- {
+		line := lines[i]
+		// The whole log stays bright while typing
+		age := now.Sub(line.At)
+		if c.IsOpen() {
+			age = 0
+		}
+		vp.DrawText(0, y, line.From+": "+line.Text, chatColor(line.Own, age), ColorDefault)
+		y--
+	}
+}
@@ -0,0 +1,153 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"shooter-duel/game"
+)
+
+// FrameBudget is how long drawing one frame may take before it counts as
+// slow: half a tick, leaving the rest for the simulation and the network
+const FrameBudget = game.TickInterval / 2
+
+// FrameStats describes how long frames took to draw and send
+type FrameStats struct {
+	Frames     int           // frames flushed
+	Cells      int           // cells passed to the target in the last frame
+	Last       time.Duration // duration of the last frame
+	Total      time.Duration // duration of all frames
+	Max        time.Duration // slowest frame
+	OverBudget int           // frames slower than the budget
+}
+
+// Average returns the mean frame duration
+func (s FrameStats) Average() time.Duration {
+	if s.Frames == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Frames)
+}
+
+func (s FrameStats) String() string {
+	return fmt.Sprintf("%d frames, avg %s, max %s, %d over budget, %d cells passed to the screen in the last frame",
+		s.Frames, s.Average(), s.Max, s.OverBudget, s.Cells)
+}
+
+// DiffScreen draws frames into a back buffer and, on Flush, passes only the
+// cells that changed since the previous frame to the target screen, which
+// is no longer cleared every frame. It also measures how long each frame
+// takes, from the first drawing call to the end of the flush.
+//
+// termbox already sends the terminal only the cells that differ from what
+// it shows, so over termbox this saves calls into termbox rather than
+// terminal traffic, and Cells counts what termbox was given, not the bytes
+// written to the terminal.
+type DiffScreen struct {
+	Target Screen
+	Budget time.Duration
+
+	back, front *Buffer
+	full        bool // the next flush redraws every cell
+	frameStart  time.Time
+	stats       FrameStats
+}
+
+// NewDiffScreen wraps the target screen with the default frame budget
+func NewDiffScreen(target Screen) *DiffScreen {
+	return &DiffScreen{Target: target, Budget: FrameBudget, full: true}
+}
+
+// Size returns the target's size
+func (d *DiffScreen) Size() (int, int) {
+	return d.Target.Size()
+}
+
+// begin starts timing a frame and sizes the buffers on first use
+func (d *DiffScreen) begin() {
+	if d.frameStart.IsZero() {
+		d.frameStart = time.Now()
+	}
+	if d.back == nil {
+		d.resize()
+	}
+}
+
+// resize matches the buffers to the target's size, forcing a full redraw
+// when it changed
+func (d *DiffScreen) resize() {
+	w, h := d.Target.Size()
+	if d.back != nil && d.back.Width == w && d.back.Height == h {
+		return
+	}
+	d.back = NewBuffer(w, h)
+	d.front = NewBuffer(w, h)
+	d.full = true
+}
+
+// Clear starts a new frame. The target is only cleared when the terminal
+// was resized.
+func (d *DiffScreen) Clear(fg, bg Color) {
+	d.begin()
+	d.resize()
+	d.back.Clear(fg, bg)
+}
+
+// SetCell sets a cell of the frame being drawn
+func (d *DiffScreen) SetCell(x, y int, ch rune, fg, bg Color) {
+	d.begin()
+	d.back.SetCell(x, y, ch, fg, bg)
+}
+
+// Flush passes the changed cells to the target and shows them
+func (d *DiffScreen) Flush() error {
+	d.begin()
+	if d.full {
+		d.Target.Clear(ColorDefault, ColorDefault)
+	}
+	cells := 0
+	for i, cell := range d.back.Cells {
+		if !d.full && d.front.Cells[i] == cell {
+			continue
+		}
+		d.Target.SetCell(i%d.back.Width, i/d.back.Width, cell.Ch, cell.Fg, cell.Bg)
+		d.front.Cells[i] = cell
+		cells++
+	}
+	d.full = false
+	err := d.Target.Flush()
+
+	d.record(time.Since(d.frameStart), cells)
+	d.frameStart = time.Time{}
+	return err
+}
+
+// Invalidate makes the next flush redraw every cell, for when the terminal
+// contents were disturbed behind our back
+func (d *DiffScreen) Invalidate() {
+	d.full = true
+}
+
+// record adds one frame to the stats
+func (d *DiffScreen) record(elapsed time.Duration, cells int) {
+	d.stats.Frames++
+	d.stats.Cells = cells
+	d.stats.Last = elapsed
+	d.stats.Total += elapsed
+	if elapsed > d.stats.Max {
+		d.stats.Max = elapsed
+	}
+	if d.Budget > 0 && elapsed > d.Budget {
+		d.stats.OverBudget++
+	}
+}
+
+// Stats returns the frame statistics since the last reset
+func (d *DiffScreen) Stats() FrameStats {
+	return d.stats
+}
+
+// ResetStats starts collecting frame statistics afresh
+func (d *DiffScreen) ResetStats() {
+	d.stats = FrameStats{}
+}
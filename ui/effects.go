@@ -0,0 +1,130 @@
+package ui
+
+import (
+	"math"
+	"time"
+
+	"shooter-duel/game"
+)
+
+// Animation timings. Animations run on the wall clock rather than the
+// simulation tick, and the match screen is redrawn every
+// AnimationInterval while one is running.
+const (
+	FlashDuration     = 400 * time.Millisecond // a hit player's sprite blinks
+	FlashPeriod       = 100 * time.Millisecond
+	BurstDuration     = 600 * time.Millisecond // particles fly off a destroyed ship
+	ShakeDuration     = 300 * time.Millisecond // the arena shakes when we are hit
+	ShakePeriod       = 50 * time.Millisecond
+	AnimationInterval = 25 * time.Millisecond
+)
+
+// burstSpeed is how many rows per second burst particles travel; cells are
+// about twice as tall as they are wide, so they cover twice as many columns
+const burstSpeed = 10.0
+
+// burstDirections are the directions burst particles fly in
+var burstDirections = [][2]float64{
+	{1, 0}, {-1, 0}, {0, 1}, {0, -1},
+	{0.7, 0.7}, {-0.7, 0.7}, {0.7, -0.7}, {-0.7, -0.7},
+}
+
+// burst is a particle burst started at an arena position
+type burst struct {
+	X, Y float64
+	At   time.Time
+}
+
+// Effects plays the short animations triggered by game events
+type Effects struct {
+	ownID   int // our player, whose hits shake the arena
+	flashes map[int]time.Time
+	bursts  []burst
+	shake   time.Time
+}
+
+// NewEffects creates the effects for the player with the given ID
+func NewEffects(ownID int) *Effects {
+	return &Effects{ownID: ownID, flashes: make(map[int]time.Time)}
+}
+
+// Trigger starts the animations for one event
+func (e *Effects) Trigger(ev game.Event, now time.Time) {
+	switch ev.Kind {
+	case game.EventHit:
+		e.flashes[ev.PlayerID] = now
+	case game.EventDeath:
+		e.bursts = append(e.bursts, burst{X: ev.X, Y: ev.Y, At: now})
+	default:
+		return
+	}
+	if ev.PlayerID == e.ownID {
+		e.shake = now
+	}
+}
+
+// Active reports whether an animation is still running, forgetting the
+// finished ones
+func (e *Effects) Active(now time.Time) bool {
+	for id, start := range e.flashes {
+		if now.Sub(start) >= FlashDuration {
+			delete(e.flashes, id)
+		}
+	}
+	running := e.bursts[:0]
+	for _, b := range e.bursts {
+		if now.Sub(b.At) < BurstDuration {
+			running = append(running, b)
+		}
+	}
+	e.bursts = running
+	return len(e.flashes) > 0 || len(e.bursts) > 0 || e.Shake(now) != 0
+}
+
+// Flash reports whether a player's sprite is drawn in the flash color
+func (e *Effects) Flash(playerID int, now time.Time) bool {
+	start, ok := e.flashes[playerID]
+	if !ok {
+		return false
+	}
+	age := now.Sub(start)
+	return age < FlashDuration && age/FlashPeriod%2 == 0
+}
+
+// Shake returns how many columns the arena is moved while it shakes
+func (e *Effects) Shake(now time.Time) int {
+	if e.shake.IsZero() {
+		return 0
+	}
+	age := now.Sub(e.shake)
+	if age >= ShakeDuration {
+		return 0
+	}
+	if age/ShakePeriod%2 == 0 {
+		return 1
+	}
+	return -1
+}
+
+// DrawBursts draws the particles of the running bursts, going through the
+// frames of the explosion sprite as they fly out
+func (e *Effects) DrawBursts(vp Viewport, now time.Time) {
+	for _, b := range e.bursts {
+		age := now.Sub(b.At)
+		if age >= BurstDuration {
+			continue
+		}
+		explosion := game.Sprites.Explosion
+		n := int(age * time.Duration(len(explosion.Frames)) / BurstDuration)
+		frame, fg := explosion.Frame(n), palette.Burst
+		if n > 0 {
+			fg = palette.BurstFade
+		}
+		dist := age.Seconds() * burstSpeed
+		for _, d := range burstDirections {
+			x := int(math.Round(b.X + d[0]*dist*2))
+			y := int(math.Round(b.Y + d[1]*dist))
+			vp.DrawEntity(x, y, frame, fg, ColorDefault)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"shooter-duel/game"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Health bar cells for remaining and lost health points
+const (
+	HealthFull  = '█'
+	HealthEmpty = '░'
+)
+
+// HealthBar returns a bar with one cell per health point out of max
+func HealthBar(health, max int) string {
+	if health < 0 {
+		health = 0
+	}
+	if max < health {
+		max = health
+	}
+	return strings.Repeat(string(HealthFull), health) + strings.Repeat(string(HealthEmpty), max-health)
+}
+
+// FormatElapsed formats a number of ticks as minutes and seconds
+func FormatElapsed(ticks int) string {
+	d := time.Duration(ticks) * game.TickInterval
+	return fmt.Sprintf("%02d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// FormatRTT formats the round trip time, or a placeholder before the first
+// measurement
+func FormatRTT(rtt time.Duration) string {
+	if rtt <= 0 {
+		return "RTT --"
+	}
+	return fmt.Sprintf("RTT %dms", rtt.Milliseconds())
+}
+
+// fitText cuts text to at most width cells
+func fitText(text string, width int) string {
+	return runewidth.Truncate(text, width, "")
+}
+
+// DrawHUD draws the strip above the arena: our health bar on the left,
+// labelled "You", the opponent's on the right, and the round, score, match
+// time and round trip time in the gap between them, with a note when
+// sounds are muted. Match details that don't fit are left out, last first.
+func DrawHUD(gs *game.GameState, view View, ms *MatchScreen, vp Viewport) {
+	y := vp.HUDRow()
+	me, opponent := gs.Players[view.Seat], gs.Players[1-view.Seat]
+
+	// Long names are shortened so the health bars always show
+	side := (vp.Width - 1) / 2
+	bar := HealthBar(me.Health, gs.Settings.Health)
+	left := fitText(perspectiveLabel(me, true), side-runewidth.StringWidth(bar)-1) + " " + bar
+	bar = HealthBar(opponent.Health, gs.Settings.Health)
+	right := bar + " " + fitText(perspectiveLabel(opponent, false), side-runewidth.StringWidth(bar)-1)
+
+	details := []string{}
+	if gs.Settings.Rounds > 1 {
+		details = append(details,
+			fmt.Sprintf("Round %d/%d", gs.Round, gs.Settings.Rounds),
+			fmt.Sprintf("%d-%d", me.Wins, opponent.Wins))
+	}
+	details = append(details, FormatElapsed(gs.Elapsed), FormatRTT(ms.RTT))
+	if ms.Muted {
+		details = append(details, "Muted")
+	}
+
+	room := vp.Width - runewidth.StringWidth(left) - runewidth.StringWidth(right) - 2
+	center := strings.Join(details, "  ")
+	for len(details) > 0 && runewidth.StringWidth(center) > room {
+		details = details[:len(details)-1]
+		center = strings.Join(details, "  ")
+	}
+
+	DrawText(vp.X, y, left, playerColor(true), ColorDefault)
+	DrawText(vp.X+vp.Width-runewidth.StringWidth(right), y, right, playerColor(false), ColorDefault)
+	x := vp.X + runewidth.StringWidth(left) + 1 + (room-runewidth.StringWidth(center))/2
+	DrawText(x, y, center, palette.Info, ColorDefault)
+}
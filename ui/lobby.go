@@ -0,0 +1,220 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	"shooter-duel/game"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Lobby form fields
+const (
+	LobbyFieldName = iota
+	LobbyFieldShip
+	LobbyFieldHealth
+	LobbyFieldRounds
+	LobbyFieldMap
+	LobbyFieldBulletSpeed
+	LobbyFieldReady
+)
+
+// ShipSettleDelay is how long the ship selection must stay unchanged before
+// it is sent to the host
+const ShipSettleDelay = 300 * time.Millisecond
+
+// LobbyActions returned by LobbyScreen.HandleKey
+const (
+	LobbyNone = iota
+	LobbyRename
+	LobbyShipChanged
+	LobbySettingsChanged
+	LobbyToggleReady
+	LobbyLeave
+)
+
+// LobbyScreen shows the players and match settings before a match. Only the
+// host can change the settings; the client sees them read-only.
+type LobbyScreen struct {
+	Lobby    game.Lobby // latest lobby state
+	Seat     int        // our seat in the lobby
+	Name     *TextInput
+	Ship     string // name of the ship we picked from our sprite pack
+	Selected int
+}
+
+// NewLobbyScreen creates the lobby form for the given seat with our name filled in
+func NewLobbyScreen(seat int, name string) *LobbyScreen {
+	input := NewTextInput("Name: ", nil)
+	input.MaxLen = game.MaxNameLength
+	input.SetText(name)
+	return &LobbyScreen{Seat: seat, Name: input, Ship: game.Sprites.DefaultShip(seat).Name}
+}
+
+// IsHost reports whether we can change the match settings
+func (ls *LobbyScreen) IsHost() bool {
+	return ls.Seat == game.SeatHost
+}
+
+// fields returns the form fields we can select
+func (ls *LobbyScreen) fields() []int {
+	if ls.IsHost() {
+		return []int{LobbyFieldName, LobbyFieldShip, LobbyFieldHealth, LobbyFieldRounds, LobbyFieldMap, LobbyFieldBulletSpeed, LobbyFieldReady}
+	}
+	return []int{LobbyFieldName, LobbyFieldShip, LobbyFieldReady}
+}
+
+// HandleKey applies a key event to the lobby form and reports what the
+// player asked for. Settings changes are applied to ls.Lobby.Settings and
+// ship changes to ls.Ship.
+func (ls *LobbyScreen) HandleKey(ev termbox.Event) int {
+	if ev.Type != termbox.EventKey {
+		return LobbyNone
+	}
+
+	fields := ls.fields()
+	pos := 0
+	for i, f := range fields {
+		if f == ls.Selected {
+			pos = i
+		}
+	}
+	switch ev.Key {
+	case termbox.KeyEsc:
+		return LobbyLeave
+	case termbox.KeyArrowUp:
+		ls.Selected = fields[(pos-1+len(fields))%len(fields)]
+		return LobbyNone
+	case termbox.KeyArrowDown, termbox.KeyTab:
+		ls.Selected = fields[(pos+1)%len(fields)]
+		return LobbyNone
+	}
+
+	step := 0
+	switch ev.Key {
+	case termbox.KeyArrowLeft:
+		step = -1
+	case termbox.KeyArrowRight, termbox.KeyEnter, termbox.KeySpace:
+		step = 1
+	}
+
+	settings := &ls.Lobby.Settings
+	switch ls.Selected {
+	case LobbyFieldName:
+		if ls.Name.HandleKey(ev) == InputSubmit {
+			return LobbyRename
+		}
+		return LobbyNone
+	case LobbyFieldReady:
+		if ev.Key == termbox.KeyEnter || ev.Key == termbox.KeySpace {
+			return LobbyToggleReady
+		}
+		return LobbyNone
+	}
+
+	if step == 0 {
+		return LobbyNone
+	}
+	if ls.Selected == LobbyFieldShip {
+		ls.Ship = game.Cycle(game.Sprites.ShipNames(), ls.Ship, step)
+		return LobbyShipChanged
+	}
+	switch ls.Selected {
+	case LobbyFieldHealth:
+		settings.Health = game.Cycle(game.HealthChoices, settings.Health, step)
+	case LobbyFieldRounds:
+		settings.Rounds = game.Cycle(game.RoundChoices, settings.Rounds, step)
+	case LobbyFieldMap:
+		settings.Map = game.Cycle(game.MapNames, settings.Map, step)
+	case LobbyFieldBulletSpeed:
+		settings.BulletSpeed = game.Cycle(game.BulletSpeedChoices, settings.BulletSpeed, step)
+	}
+	return LobbySettingsChanged
+}
+
+// DrawLobbyScreen draws the lobby: both players, the match settings and
+// the countdown once everyone is ready
+func DrawLobbyScreen(ls *LobbyScreen, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	DrawCenteredText(w/2, h/2-9, "LOBBY", palette.Title, ColorDefault)
+
+	fieldWidth := 36
+	x := (w - fieldWidth) / 2
+	color := func(field int) Color {
+		if field == ls.Selected {
+			return palette.Selected
+		}
+		return palette.Text
+	}
+
+	// Players
+	for seat, p := range ls.Lobby.Players {
+		status, fg := "waiting...", palette.Faded
+		switch {
+		case !p.Connected:
+			p.Name = "(empty seat)"
+		case p.Ready:
+			status, fg = "READY", palette.Selected
+		default:
+			status, fg = "not ready", palette.Hint
+		}
+		label := fmt.Sprintf("P%d %-*s", seat+1, game.MaxNameLength, p.Name)
+		if seat == ls.Seat {
+			label += " (you)"
+		}
+		DrawText(x, h/2-7+seat, label, palette.Text, ColorDefault)
+		DrawText(x+fieldWidth-len(status), h/2-7+seat, status, fg, ColorDefault)
+	}
+
+	DrawTextInput(ls.Name, x, h/2-4, fieldWidth)
+
+	// The ship we are picking, with a preview of the one the host has for
+	// us beside the form
+	DrawText(x, h/2-2, fmt.Sprintf("< Ship:         %s >", ls.Ship), color(LobbyFieldShip), ColorDefault)
+	ship := ls.Lobby.Players[ls.Seat].Ship
+	if ship.Name != ls.Ship {
+		DrawText(x+fieldWidth+2, h/2-4, "sending...", palette.Faded, ColorDefault)
+	}
+	DrawSprite(x+fieldWidth+2, h/2-3, ship.Frame(0), palette.Own, ColorDefault)
+
+	// Match settings
+	s := ls.Lobby.Settings
+	settings := []struct {
+		field int
+		text  string
+	}{
+		{LobbyFieldHealth, fmt.Sprintf("Health:       %d", s.Health)},
+		{LobbyFieldRounds, fmt.Sprintf("Rounds:       best of %d", s.Rounds)},
+		{LobbyFieldMap, fmt.Sprintf("Map:          %s", s.Map)},
+		{LobbyFieldBulletSpeed, fmt.Sprintf("Bullet speed: %gx", s.BulletSpeed)},
+	}
+	for i, setting := range settings {
+		text := setting.text
+		if ls.IsHost() {
+			text = "< " + text + " >"
+		} else {
+			text = "  " + text
+		}
+		DrawText(x, h/2-1+i, text, color(setting.field), ColorDefault)
+	}
+
+	ready := "[ Ready ]"
+	if ls.Lobby.Players[ls.Seat].Ready {
+		ready = "[ Not ready ]"
+	}
+	DrawText(x, h/2+3, ready, color(LobbyFieldReady), ColorDefault)
+
+	if ls.Lobby.Countdown > 0 {
+		msg := fmt.Sprintf("Match starts in %d...", ls.Lobby.Countdown)
+		DrawCenteredText(w/2, h/2+5, msg, palette.Error|AttrBold, ColorDefault)
+	} else if !ls.IsHost() {
+		DrawCenteredText(w/2, h/2+5, "The host picks the match settings", palette.Faded, ColorDefault)
+	}
+
+	help := "Up/Down: Select, Left/Right: Change, Enter: Confirm, Esc: Leave"
+	DrawCenteredText(w/2, h/2+7, help, palette.Hint, ColorDefault)
+
+	output.Flush()
+}
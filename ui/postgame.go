@@ -0,0 +1,117 @@
+package ui
+
+import (
+	"fmt"
+
+	"shooter-duel/game"
+
+	"github.com/nsf/termbox-go"
+)
+
+// PostGameActions returned by PostGameScreen.HandleKey
+const (
+	PostGameNone = iota
+	PostGameVote
+	PostGameLeave
+)
+
+// PostGameScreen shows the result of a match and both players' rematch votes
+type PostGameScreen struct {
+	Result       string
+	Names        [2]string
+	Wins         [2]int
+	Stats        [2]string // shots and hits, empty without statistics
+	Seat         int       // our seat
+	Votes        [2]bool   // seats that asked for a rematch
+	OpponentLeft bool
+}
+
+// NewPostGameScreen summarizes a finished match for the player in the given
+// seat; stats may be nil
+func NewPostGameScreen(gs *game.GameState, seat int, stats *game.MatchStats) *PostGameScreen {
+	ps := &PostGameScreen{Seat: seat, Result: "Draw!"}
+	for i, p := range gs.Players {
+		ps.Names[i] = playerLabel(p)
+		ps.Wins[i] = p.Wins
+		if stats != nil {
+			ps.Stats[i] = fmt.Sprintf("%d shots, %d hits (%d%%)", stats.Shots[p.ID], stats.Hits[p.ID], stats.Accuracy(p.ID))
+		}
+		if p.ID == gs.Winner {
+			if i == seat {
+				ps.Result = "You win!"
+			} else {
+				ps.Result = ps.Names[i] + " wins!"
+			}
+		}
+	}
+	if gs.Message != "" && gs.Winner == 0 {
+		ps.Result = gs.Message
+	}
+	return ps
+}
+
+// Agreed reports whether both players voted for a rematch
+func (ps *PostGameScreen) Agreed() bool {
+	return ps.Votes[0] && ps.Votes[1]
+}
+
+// HandleKey applies a key event: Y, R or Enter votes for a rematch, N, Q or
+// Esc leaves. Once the opponent has left any key leaves.
+func (ps *PostGameScreen) HandleKey(ev termbox.Event) int {
+	if ev.Type != termbox.EventKey {
+		return PostGameNone
+	}
+	if ps.OpponentLeft {
+		return PostGameLeave
+	}
+	switch {
+	case ev.Ch == 'y' || ev.Ch == 'r' || ev.Key == termbox.KeyEnter:
+		if ps.Votes[ps.Seat] {
+			return PostGameNone
+		}
+		ps.Votes[ps.Seat] = true
+		return PostGameVote
+	case ev.Ch == 'n' || ev.Ch == 'q' || ev.Key == termbox.KeyEsc:
+		return PostGameLeave
+	}
+	return PostGameNone
+}
+
+// DrawPostGameScreen draws the match result and the rematch vote
+func DrawPostGameScreen(ps *PostGameScreen, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	DrawCenteredText(w/2, h/2-7, "MATCH OVER", palette.Title, ColorDefault)
+	DrawCenteredText(w/2, h/2-5, ps.Result, palette.Error|AttrBold, ColorDefault)
+	score := fmt.Sprintf("%s %d - %d %s", ps.Names[0], ps.Wins[0], ps.Wins[1], ps.Names[1])
+	DrawCenteredText(w/2, h/2-4, score, palette.Text, ColorDefault)
+	for seat, stats := range ps.Stats {
+		if stats != "" {
+			DrawCenteredText(w/2, h/2-2+seat, ps.Names[seat]+": "+stats, palette.Faded, ColorDefault)
+		}
+	}
+
+	for seat, name := range ps.Names {
+		status, fg := "thinking...", palette.Faded
+		if ps.Votes[seat] {
+			status, fg = "wants a rematch", palette.Selected
+		}
+		if seat != ps.Seat && ps.OpponentLeft {
+			status, fg = "left", palette.Error
+		}
+		if seat == ps.Seat {
+			name += " (you)"
+		}
+		DrawCenteredText(w/2, h/2+1+seat, name+": "+status, fg, ColorDefault)
+	}
+
+	help := "Rematch? Y: Yes, N: No (back to menu)"
+	if ps.OpponentLeft {
+		help = "Your opponent left. Press any key to return to the menu"
+	} else if ps.Votes[ps.Seat] {
+		help = "Waiting for your opponent... N: Leave"
+	}
+	DrawCenteredText(w/2, h/2+4, help, palette.Hint, ColorDefault)
+
+	output.Flush()
+}
@@ -3,9 +3,11 @@ package ui
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"shooter-duel/game"
 
+	"github.com/mattn/go-runewidth"
 	"github.com/nsf/termbox-go"
 )
 
@@ -16,6 +18,7 @@ const (
 	StateConnecting
 	StateGameRunning
 	StateGameOver
+	StateLobby
 )
 
 // MenuOptions for menu options
@@ -25,80 +28,203 @@ const (
 	MenuOptionExit
 )
 
-// DrawGame renders the game state
-func DrawGame(gs *game.GameState) {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+// View is the perspective the arena is drawn from
+type View struct {
+	Seat int  // our player's index in GameState.Players
+	Flip bool // mirror the arena vertically
+}
+
+// PlayerView returns the perspective of the player in the given seat,
+// mirrored when that player is in the top half of the arena so that both
+// players always play from the bottom of their screen
+func PlayerView(gs *game.GameState, seat int) View {
+	p := gs.Players[seat]
+	center := p.Y + float64(p.Hitbox.Height)/2
+	return View{Seat: seat, Flip: center < float64(gs.ScreenHeight)/2}
+}
+
+// MatchScreen is what the match screen shows besides the game state
+type MatchScreen struct {
+	Chat    *Chat
+	Effects *Effects
+	RTT     time.Duration // round trip time to the other player, 0 if unknown
+	Muted   bool          // sound effects are off
+}
+
+// NewMatchScreen creates the match screen of the player in the given seat
+func NewMatchScreen(gs *game.GameState, seat int) *MatchScreen {
+	return &MatchScreen{Chat: NewChat(), Effects: NewEffects(gs.Players[seat].ID)}
+}
+
+// DrawGame renders the game state from the given perspective
+func DrawGame(gs *game.GameState, view View) {
+	renderGame(gs, view, NewMatchScreen(gs, view.Seat), time.Now())
+	output.Flush()
+}
+
+// DrawMatch renders the game state with the HUD, the running animations
+// and the chat overlay on top
+func DrawMatch(gs *game.GameState, view View, ms *MatchScreen, now time.Time) {
+	if vp, ok := renderGame(gs, view, ms, now); ok {
+		DrawChat(ms.Chat, now, vp)
+	}
+	output.Flush()
+}
+
+// renderGame draws the game state centered in the terminal without
+// flushing. It draws the "terminal too small" screen instead and returns
+// false when the arena doesn't fit.
+func renderGame(gs *game.GameState, view View, ms *MatchScreen, now time.Time) (Viewport, bool) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	// The terminal size is read every frame so resizing takes effect at once
+	vp := CurrentViewport(gs.ScreenWidth, gs.ScreenHeight)
+	if !vp.Fits() {
+		DrawTooSmall(vp)
+		return vp, false
+	}
+	vp.Flip = view.Flip
+	DrawHUD(gs, view, ms, vp)
+
+	// Draw instructions below the arena
+	instructions := "A/D: Move, J: Shoot, T: Chat, 1-5: Quick chat, M: Mute, Q: Quit"
+	DrawText(vp.X, vp.FooterRow(), fitText(instructions, vp.Width), palette.Info, ColorDefault)
+
+	// The arena and its frame shake when we are hit; the HUD and the chat
+	// stay in place
+	arena := vp
+	arena.X += ms.Effects.Shake(now)
+	arena.DrawBorder(palette.Border)
 
-	// Draw players
+	// Draw obstacles
+	for _, o := range gs.Obstacles {
+		for y := o.Y; y < o.Y+o.Height; y++ {
+			for x := o.X; x < o.X+o.Width; x++ {
+				arena.DrawEntityCell(x, y, '#', palette.Obstacle, ColorDefault)
+			}
+		}
+	}
+
+	// Draw players in their own colors; a player who was just hit blinks
 	for i, player := range gs.Players {
 		if !player.Alive {
 			continue
 		}
-
-		color := termbox.ColorYellow
-		if i == 1 {
-			color = termbox.ColorMagenta
+		color := playerColor(i == view.Seat)
+		if ms.Effects.Flash(player.ID, now) {
+			color = palette.Flash
 		}
-
-		DrawSprite(int(player.X), int(player.Y), player.Sprite, color, termbox.ColorDefault)
-
-		// Draw health bar
-		healthBar := fmt.Sprintf("P%d: %d", player.ID, player.Health)
-		DrawText(0, i*2, healthBar, color, termbox.ColorDefault)
+		arena.DrawEntity(int(player.X), int(player.Y), shipFrame(gs, player, now), color, ColorDefault)
 	}
 
-	// Draw bullets
+	// Draw bullets pointing the way they fly
 	for _, b := range gs.Bullets {
-		DrawSprite(int(b.X), int(b.Y), game.Params.BulletSprite, termbox.ColorWhite, termbox.ColorDefault)
+		sprite := gs.Sprite(b.Sprite).Frame(0)
+		if b.Speed > 0 {
+			sprite = FlipSprite(sprite)
+		}
+		arena.DrawEntity(int(b.X), int(b.Y), sprite, palette.Bullet, ColorDefault)
 	}
 
-	// Draw instructions
-	instructions := "A/D: Move, J: Shoot, Q: Quit"
-	DrawText(0, gs.ScreenHeight-1, instructions, termbox.ColorCyan, termbox.ColorDefault)
+	ms.Effects.DrawBursts(arena, now)
+
+	// Draw the result of the last round until the next one starts
+	if gs.RoundOver > 0 && !gs.IsGameOver {
+		arena.DrawCenteredText(gs.ScreenHeight/2, gs.Message, palette.Hint, ColorDefault)
+	}
 
 	// Draw game over message
 	if gs.IsGameOver {
-		msg := "GAME OVER"
-		if gs.Winner > 0 {
-			msg = fmt.Sprintf("Player %d Wins!", gs.Winner)
+		msg := "Draw!"
+		switch gs.Winner {
+		case 0:
+		case gs.Players[view.Seat].ID:
+			msg = "You win!"
+		default:
+			msg = "You lose!"
+		}
+		if gs.Message != "" {
+			msg = gs.Message
 		}
-		DrawCenteredText(gs.ScreenWidth/2, gs.ScreenHeight/2, msg, termbox.ColorRed, termbox.ColorDefault)
+		arena.DrawCenteredText(gs.ScreenHeight/2, msg, palette.Error, ColorDefault)
+	}
+	return vp, true
+}
+
+// SpriteFrameInterval is how long each animation frame of a ship shows
+const SpriteFrameInterval = 200 * time.Millisecond
+
+// shipFrame returns the animation frame of a player's ship to draw now,
+// upside down for ships at the top of the arena
+func shipFrame(gs *game.GameState, p *game.Player, now time.Time) []string {
+	frame := gs.Sprite(p.Sprite).Frame(int(now.UnixNano() / int64(SpriteFrameInterval)))
+	if p.Y < float64(gs.ScreenHeight)/2 {
+		frame = FlipSprite(frame)
 	}
+	return frame
+}
 
-	termbox.Flush()
+// playerColor returns the color of our own player or of the opponent
+func playerColor(own bool) Color {
+	if own {
+		return palette.Own
+	}
+	return palette.Opponent
+}
+
+// playerLabel returns the player's display name, or "P1"/"P2" without one
+func playerLabel(p *game.Player) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return fmt.Sprintf("P%d", p.ID)
+}
+
+// perspectiveLabel names a player from the viewer's side: "You" or
+// "Opponent", with the player's name when they have one
+func perspectiveLabel(p *game.Player, own bool) string {
+	label := "Opponent"
+	if own {
+		label = "You"
+	}
+	if p.Name != "" {
+		label += " (" + p.Name + ")"
+	}
+	return label
 }
 
 // DrawSprite draws a sprite at the specified position
-func DrawSprite(x, y int, sprite []string, fg, bg termbox.Attribute) {
-	w, h := termbox.Size()
+func DrawSprite(x, y int, sprite []string, fg, bg Color) {
 	for row, line := range sprite {
-		for col, char := range line {
-			if x+col >= 0 && x+col < w && y+row >= 0 && y+row < h {
-				termbox.SetCell(x+col, y+row, char, fg, bg)
-			}
-		}
+		DrawText(x, y+row, line, fg, bg)
 	}
 }
 
-// DrawText draws text at the specified position
-func DrawText(x, y int, text string, fg, bg termbox.Attribute) {
-	w, h := termbox.Size()
-	for i, char := range text {
-		if x+i >= 0 && x+i < w && y >= 0 && y < h {
-			termbox.SetCell(x+i, y, char, fg, bg)
+// DrawText draws text at the specified position. Wide runes take two cells
+// and zero width runes, which can't have a cell of their own, are dropped.
+func DrawText(x, y int, text string, fg, bg Color) {
+	w, h := output.Size()
+	for _, char := range text {
+		width := runewidth.RuneWidth(char)
+		if width == 0 {
+			continue
+		}
+		if x >= 0 && x+width <= w && y >= 0 && y < h {
+			output.SetCell(x, y, char, fg, bg)
 		}
+		x += width
 	}
 }
 
 // DrawCenteredText draws horizontally centered text
-func DrawCenteredText(centerX, y int, text string, fg, bg termbox.Attribute) {
-	x := centerX - len(text)/2
+func DrawCenteredText(centerX, y int, text string, fg, bg Color) {
+	x := centerX - runewidth.StringWidth(text)/2
 	DrawText(x, y, text, fg, bg)
 }
 
 // DrawMenu draws the main menu
 func DrawMenu(selectedOption, w, h int) {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	output.Clear(ColorDefault, ColorDefault)
 	title := "ONLINE SHOOTER DUEL"
 	menuOptions := []string{
 		"Create Room (Host)",
@@ -108,31 +234,31 @@ func DrawMenu(selectedOption, w, h int) {
 	xTitle := (w - len(title)) / 2
 	yTitle := h/2 - 4
 	for i, r := range title {
-		termbox.SetCell(xTitle+i, yTitle, r, termbox.ColorCyan, termbox.ColorDefault)
+		output.SetCell(xTitle+i, yTitle, r, palette.Title, ColorDefault)
 	}
 	yMenu := h / 2
 	for i, option := range menuOptions {
 		xOption := (w - len(option)) / 2
-		color := termbox.ColorWhite
+		color := palette.Text
 		if i == selectedOption {
-			color = termbox.ColorGreen
+			color = palette.Selected
 		}
 		for j, r := range option {
-			termbox.SetCell(xOption+j, yMenu+i, r, color, termbox.ColorDefault)
+			output.SetCell(xOption+j, yMenu+i, r, color, ColorDefault)
 		}
 	}
 	startMsg := "Use Arrows to select, Enter to confirm"
 	xStart := (w - len(startMsg)) / 2
 	yStart := h/2 + 4
 	for i, r := range startMsg {
-		termbox.SetCell(xStart+i, yStart, r, termbox.ColorYellow, termbox.ColorDefault)
+		output.SetCell(xStart+i, yStart, r, palette.Hint, ColorDefault)
 	}
-	termbox.Flush()
+	output.Flush()
 }
 
 // DrawWaitingScreen draws the waiting screen
 func DrawWaitingScreen(message string, w, h int) {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	output.Clear(ColorDefault, ColorDefault)
 
 	// Split the message into lines
 	lines := strings.Split(message, "\n")
@@ -145,16 +271,16 @@ func DrawWaitingScreen(message string, w, h int) {
 		yMsg := startY + lineIndex
 
 		for i, r := range line {
-			termbox.SetCell(xMsg+i, yMsg, r, termbox.ColorYellow, termbox.ColorDefault)
+			output.SetCell(xMsg+i, yMsg, r, palette.Hint, ColorDefault)
 		}
 	}
 
-	termbox.Flush()
+	output.Flush()
 }
 
 // DrawGameOver draws the game over screen
 func DrawGameOver(score int, message, restartMsg string, w, h int) {
-	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	output.Clear(ColorDefault, ColorDefault)
 	msg := message
 	if score > 0 {
 		msg = fmt.Sprintf("%s - Final Score: %d", message, score)
@@ -165,10 +291,318 @@ func DrawGameOver(score int, message, restartMsg string, w, h int) {
 	xRestart := (w - len(restart)) / 2
 	yRestart := h/2 + 1
 	for i, r := range msg {
-		termbox.SetCell(xMsg+i, yMsg, r, termbox.ColorRed, termbox.ColorDefault)
+		output.SetCell(xMsg+i, yMsg, r, palette.Error, ColorDefault)
 	}
 	for i, r := range restart {
-		termbox.SetCell(xRestart+i, yRestart, r, termbox.ColorWhite, termbox.ColorDefault)
+		output.SetCell(xRestart+i, yRestart, r, palette.Text, ColorDefault)
 	}
-	termbox.Flush()
+	output.Flush()
+}
+
+// RoomEntry is one row of the discovered room list on the join screen
+type RoomEntry struct {
+	Name       string
+	Address    string
+	Players    int
+	MaxPlayers int
+	TLS        bool
+	Locked     bool
+	Joinable   bool
+}
+
+// JoinScreen holds the state of the join screen: rooms discovered on the LAN
+// and the manual host address field
+type JoinScreen struct {
+	Input        *TextInput
+	Rooms        []RoomEntry
+	SelectedRoom int
+	ListFocused  bool
+}
+
+// DrawJoinScreen draws the discovered room list and the host address form
+func DrawJoinScreen(js *JoinScreen, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	fieldWidth := 44
+	if fieldWidth > w-2 {
+		fieldWidth = w - 2
+	}
+	x := (w - fieldWidth) / 2
+
+	listRows := len(js.Rooms)
+	if listRows == 0 {
+		listRows = 1
+	}
+	y := h/2 - (listRows+8)/2
+
+	DrawCenteredText(w/2, y, "JOIN ROOM", palette.Title, ColorDefault)
+	y += 2
+
+	labelColor := palette.Text
+	if js.ListFocused {
+		labelColor = palette.Selected
+	}
+	DrawText(x, y, "Rooms on your network:", labelColor, ColorDefault)
+	y++
+	if len(js.Rooms) == 0 {
+		DrawText(x, y, "  Searching...", palette.Faded, ColorDefault)
+		y++
+	}
+	for i, room := range js.Rooms {
+		row := fmt.Sprintf("  %-20s %d/%d  %s", room.Name, room.Players, room.MaxPlayers, room.Address)
+		if room.TLS {
+			row += "  [TLS]"
+		}
+		if room.Locked {
+			row += "  [password]"
+		}
+		color := palette.Text
+		if !room.Joinable {
+			row += "  (incompatible)"
+			color = palette.Faded
+		}
+		if js.ListFocused && i == js.SelectedRoom {
+			row = ">" + row[1:]
+			color = palette.Selected
+		}
+		DrawText(x, y, row, color, ColorDefault)
+		y++
+	}
+
+	y++
+	DrawTextInput(js.Input, x, y, fieldWidth)
+
+	help := "Tab: Rooms/Manual, Enter: Connect, Up/Down: Select, Esc: Back"
+	DrawCenteredText(w/2, y+3, help, palette.Hint, ColorDefault)
+
+	output.Flush()
+}
+
+// HostAddress is one row of the address list on the host screen
+type HostAddress struct {
+	Interface string
+	Address   string // host:port form ready to share
+	Virtual   bool
+}
+
+// HostScreen holds the state of an open room's waiting screen
+type HostScreen struct {
+	Addrs       []HostAddress
+	Selected    int    // address highlighted as the one to share
+	Fingerprint string // TLS certificate fingerprint, empty without TLS
+}
+
+// DrawHostScreen draws the waiting screen of an open room, listing every
+// local address with the selected one highlighted as the one to share
+func DrawHostScreen(hs *HostScreen, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	startY := h/2 - (len(hs.Addrs)+11)/2
+	DrawCenteredText(w/2, startY, "Room created! Waiting for player to connect...", palette.Hint, ColorDefault)
+	DrawCenteredText(w/2, startY+2, "Local addresses:", palette.Text, ColorDefault)
+
+	// Rows are left aligned in a centered column
+	rows := make([]string, len(hs.Addrs))
+	rowWidth := 0
+	for i, addr := range hs.Addrs {
+		rows[i] = fmt.Sprintf("  %-12s %s", addr.Interface, addr.Address)
+		if addr.Virtual {
+			rows[i] += "  (virtual?)"
+		}
+		if i == hs.Selected {
+			rows[i] = ">" + rows[i][1:]
+		}
+		if len(rows[i]) > rowWidth {
+			rowWidth = len(rows[i])
+		}
+	}
+	for i, row := range rows {
+		color := palette.Text
+		if hs.Addrs[i].Virtual {
+			color = palette.Faded
+		}
+		if i == hs.Selected {
+			color = palette.Selected
+		}
+		DrawText((w-rowWidth)/2, startY+3+i, row, color, ColorDefault)
+	}
+
+	y := startY + 4 + len(hs.Addrs)
+	if hs.Selected >= 0 && hs.Selected < len(hs.Addrs) {
+		DrawCenteredText(w/2, y, "Share: "+hs.Addrs[hs.Selected].Address, palette.Selected|AttrBold, ColorDefault)
+	}
+	y += 2
+	if hs.Fingerprint != "" {
+		DrawCenteredText(w/2, y, "TLS fingerprint (compare with the other player):", palette.Text, ColorDefault)
+		DrawCenteredText(w/2, y+1, hs.Fingerprint, palette.Info, ColorDefault)
+	} else {
+		DrawCenteredText(w/2, y, "Encryption off: traffic can be read on the network", palette.Faded, ColorDefault)
+	}
+	DrawCenteredText(w/2, y+3, "Up/Down/Tab: Choose address, Esc: Cancel", palette.Info, ColorDefault)
+
+	output.Flush()
+}
+
+// Fields of the create room form
+const (
+	CreateFieldTLS = iota
+	CreateFieldPassword
+	CreateFieldArena
+	CreateFieldStart
+	createFieldCount
+)
+
+// CreateRoomScreen holds the options chosen before opening a room
+type CreateRoomScreen struct {
+	TLS      bool
+	Password *TextInput
+	Arena    game.Arena
+	Selected int
+}
+
+// NewCreateRoomScreen creates the room options form with encryption on
+func NewCreateRoomScreen() *CreateRoomScreen {
+	password := NewTextInput("Password: ", nil)
+	password.Mask = '*'
+	return &CreateRoomScreen{TLS: true, Password: password, Arena: game.DefaultArena}
+}
+
+// HandleKey applies a key event to the create room form and reports whether
+// the host confirmed or cancelled
+func (cs *CreateRoomScreen) HandleKey(ev termbox.Event) int {
+	if ev.Type != termbox.EventKey {
+		return InputEditing
+	}
+	switch ev.Key {
+	case termbox.KeyEsc:
+		return InputCancel
+	case termbox.KeyArrowUp:
+		cs.Selected = (cs.Selected - 1 + createFieldCount) % createFieldCount
+		return InputEditing
+	case termbox.KeyArrowDown, termbox.KeyTab:
+		cs.Selected = (cs.Selected + 1) % createFieldCount
+		return InputEditing
+	}
+
+	switch cs.Selected {
+	case CreateFieldTLS:
+		if ev.Key == termbox.KeyEnter || ev.Key == termbox.KeySpace {
+			cs.TLS = !cs.TLS
+		}
+	case CreateFieldPassword:
+		// Enter in the password field creates the room
+		return cs.Password.HandleKey(ev)
+	case CreateFieldArena:
+		switch ev.Key {
+		case termbox.KeyArrowLeft:
+			cs.Arena = game.Cycle(game.ArenaSizes, cs.Arena, -1)
+		case termbox.KeyArrowRight, termbox.KeySpace:
+			cs.Arena = game.Cycle(game.ArenaSizes, cs.Arena, 1)
+		case termbox.KeyEnter:
+			return InputSubmit
+		}
+	case CreateFieldStart:
+		if ev.Key == termbox.KeyEnter {
+			return InputSubmit
+		}
+	}
+	return InputEditing
+}
+
+// DrawCreateRoomScreen draws the room options form
+func DrawCreateRoomScreen(cs *CreateRoomScreen, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	DrawCenteredText(w/2, h/2-5, "CREATE ROOM", palette.Title, ColorDefault)
+
+	fieldWidth := 36
+	x := (w - fieldWidth) / 2
+	color := func(field int) Color {
+		if field == cs.Selected {
+			return palette.Selected
+		}
+		return palette.Text
+	}
+
+	tls := "Off"
+	if cs.TLS {
+		tls = "On"
+	}
+	DrawText(x, h/2-2, "Encryption (TLS): "+tls, color(CreateFieldTLS), ColorDefault)
+
+	DrawTextInput(cs.Password, x, h/2, fieldWidth)
+	note := "Leave empty for an open room"
+	if cs.Password.Text() != "" && !cs.TLS {
+		note = "Tip: turn encryption on too"
+	}
+	if cs.Password.Error == "" {
+		DrawText(x+len(cs.Password.Label), h/2+1, note, palette.Faded, ColorDefault)
+	}
+
+	arena := "< Arena: " + cs.Arena.String() + " >"
+	DrawText(x, h/2+3, arena, color(CreateFieldArena), ColorDefault)
+	if !cs.Arena.Fits(w, h) {
+		DrawText(x+len(arena)+1, h/2+3, "(larger than your terminal)", palette.Error, ColorDefault)
+	}
+
+	DrawText(x, h/2+5, "[ Create Room ]", color(CreateFieldStart), ColorDefault)
+
+	help := "Up/Down: Select, Enter/Space: Toggle, Left/Right: Change, Esc: Back"
+	DrawCenteredText(w/2, h/2+8, help, palette.Hint, ColorDefault)
+
+	output.Flush()
+}
+
+// DrawPasswordPrompt draws the room password form shown while joining
+func DrawPasswordPrompt(input *TextInput, host string, w, h int) {
+	output.Clear(ColorDefault, ColorDefault)
+
+	DrawCenteredText(w/2, h/2-3, "Room "+host+" is password protected", palette.Title, ColorDefault)
+
+	fieldWidth := 36
+	DrawTextInput(input, (w-fieldWidth)/2, h/2, fieldWidth)
+
+	DrawCenteredText(w/2, h/2+3, "Enter: Join, Esc: Cancel", palette.Hint, ColorDefault)
+
+	output.Flush()
+}
+
+// DrawSecurityWarning draws the warning shown when a host's certificate
+// fingerprint differs from the one pinned on first connection
+func DrawSecurityWarning(host, known, presented string, w, h int) {
+	// The whole screen is drawn reversed in the error color, so it stands
+	// out in every theme, even without colors
+	warning := palette.Error | AttrReverse
+	output.Clear(warning, ColorDefault)
+
+	lines := []string{
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@",
+		"@    WARNING: HOST IDENTITY HAS CHANGED!         @",
+		"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@",
+		"",
+		"Someone may be intercepting your connection to " + host,
+		"or the host reinstalled the game.",
+		"",
+		"Pinned fingerprint:",
+		known,
+	}
+	if presented == "" {
+		lines = append(lines, "", "The host now offers no encryption at all.")
+	} else {
+		lines = append(lines, "", "Presented fingerprint:", presented)
+	}
+	lines = append(lines,
+		"",
+		"The connection was refused. If the change is expected, remove the",
+		"host's line from the known_hosts file in your config directory.",
+		"",
+		"Press any key to go back",
+	)
+
+	startY := h/2 - len(lines)/2
+	for i, line := range lines {
+		DrawCenteredText(w/2, startY+i, line, warning|AttrBold, ColorDefault)
+	}
+
+	output.Flush()
 }
@@ -0,0 +1,255 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Color is a cell's foreground or background color, optionally combined
+// with style attributes using bitwise OR. The values match termbox's.
+type Color uint64
+
+// Cell colors
+const (
+	ColorDefault Color = iota
+	ColorBlack
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorMagenta
+	ColorCyan
+	ColorWhite
+)
+
+// Cell attributes
+const (
+	AttrBold Color = 1 << (iota + 9)
+	AttrBlink
+	AttrHidden
+	AttrDim
+	AttrUnderline
+	AttrCursive
+	AttrReverse
+)
+
+// attrMask selects the attributes of a Color, colorMask its basic or
+// 256-color part
+const (
+	attrMask  = AttrBold | AttrBlink | AttrHidden | AttrDim | AttrUnderline | AttrCursive | AttrReverse
+	colorMask = 0x1FF
+)
+
+// rgbFlag marks a truecolor Color; the red, green and blue components sit
+// above the attributes, where termbox's RGBToAttribute puts them
+const (
+	rgbShift       = 16
+	rgbFlag  Color = 1 << (25 + rgbShift)
+)
+
+// Color256 returns the color with the given index of the 256-color palette;
+// terminals only show it in Colors256 mode
+func Color256(index uint8) Color {
+	return Color(index) + 1
+}
+
+// RGB returns a truecolor color; terminals only show it in ColorsRGB mode
+func RGB(r, g, b uint8) Color {
+	return rgbFlag | Color(r)<<(rgbShift+16) | Color(g)<<(rgbShift+8) | Color(b)<<rgbShift
+}
+
+// ColorMode is how many colors the terminal shows
+type ColorMode int
+
+// Color modes, from the basic 8 colors every color terminal has to none
+const (
+	Colors8 ColorMode = iota
+	Colors256
+	ColorsRGB
+	ColorsNone // attributes only, for monochrome terminals
+)
+
+func (m ColorMode) String() string {
+	switch m {
+	case Colors8:
+		return "8 colors"
+	case Colors256:
+		return "256 colors"
+	case ColorsRGB:
+		return "truecolor"
+	case ColorsNone:
+		return "no colors"
+	}
+	return "unknown color mode"
+}
+
+// DetectColorMode guesses the terminal's color support from the
+// environment: NO_COLOR turns colors off, COLORTERM announces truecolor and
+// TERM names 256-color and dumb terminals
+func DetectColorMode(getenv func(string) string) ColorMode {
+	term := getenv("TERM")
+	switch {
+	case getenv("NO_COLOR") != "" || term == "dumb":
+		return ColorsNone
+	case getenv("COLORTERM") == "truecolor" || getenv("COLORTERM") == "24bit":
+		return ColorsRGB
+	case strings.Contains(term, "256color"):
+		return Colors256
+	}
+	return Colors8
+}
+
+// Screen is a grid of character cells the ui draws on
+type Screen interface {
+	Size() (width, height int)
+	Clear(fg, bg Color)
+	SetCell(x, y int, ch rune, fg, bg Color)
+	Flush() error
+}
+
+// output is the screen every drawing function renders to
+var output Screen = TermboxScreen{}
+
+// SetScreen makes the ui draw on the given screen and returns the previous
+// one, so tests can render into a Buffer
+func SetScreen(s Screen) Screen {
+	previous := output
+	output = s
+	return previous
+}
+
+// =============================================================================
+// TERMBOX SCREEN
+// =============================================================================
+
+// TermboxScreen draws on the terminal through termbox in one color mode
+type TermboxScreen struct {
+	Mode ColorMode
+}
+
+// NewTermboxScreen switches termbox to the color mode and returns a screen
+// drawing in it. termbox must be initialized first.
+func NewTermboxScreen(mode ColorMode) TermboxScreen {
+	switch mode {
+	case Colors256:
+		termbox.SetOutputMode(termbox.Output256)
+	case ColorsRGB:
+		termbox.SetOutputMode(termbox.OutputRGB)
+	default:
+		termbox.SetOutputMode(termbox.OutputNormal)
+	}
+	return TermboxScreen{Mode: mode}
+}
+
+// Size returns the terminal size
+func (TermboxScreen) Size() (int, int) {
+	return termbox.Size()
+}
+
+// Clear clears the back buffer, picking up terminal resizes
+func (s TermboxScreen) Clear(fg, bg Color) {
+	termbox.Clear(s.attribute(fg), s.attribute(bg))
+}
+
+// SetCell sets a cell in the back buffer
+func (s TermboxScreen) SetCell(x, y int, ch rune, fg, bg Color) {
+	termbox.SetCell(x, y, ch, s.attribute(fg), s.attribute(bg))
+}
+
+// attribute converts a color for the screen's color mode. Monochrome
+// screens keep only the attributes. In truecolor mode termbox reads every
+// color as RGB, so basic and 256-palette colors are converted; the default
+// color with attributes would come out black and is drawn light gray.
+func (s TermboxScreen) attribute(c Color) termbox.Attribute {
+	switch s.Mode {
+	case ColorsNone:
+		c &= attrMask
+	case ColorsRGB:
+		if c&rgbFlag == 0 && c != ColorDefault {
+			attrs := c & attrMask
+			r, g, b := paletteRGB(int(c&colorMask) - 1)
+			c = RGB(r, g, b) | attrs
+		}
+	}
+	return termbox.Attribute(c)
+}
+
+// Flush shows the back buffer on the terminal
+func (TermboxScreen) Flush() error {
+	return termbox.Flush()
+}
+
+// =============================================================================
+// IN-MEMORY SCREEN
+// =============================================================================
+
+// Cell is one character cell of a Buffer
+type Cell struct {
+	Ch     rune
+	Fg, Bg Color
+}
+
+// Buffer is an in-memory screen, used for tests and as a frame buffer
+type Buffer struct {
+	Width, Height int
+	Cells         []Cell
+	Flushes       int
+}
+
+// NewBuffer creates a blank buffer of the given size
+func NewBuffer(width, height int) *Buffer {
+	b := &Buffer{Width: width, Height: height, Cells: make([]Cell, width*height)}
+	b.Clear(ColorDefault, ColorDefault)
+	return b
+}
+
+// Size returns the buffer size
+func (b *Buffer) Size() (int, int) {
+	return b.Width, b.Height
+}
+
+// Clear fills the buffer with blank cells
+func (b *Buffer) Clear(fg, bg Color) {
+	for i := range b.Cells {
+		b.Cells[i] = Cell{Ch: ' ', Fg: fg, Bg: bg}
+	}
+}
+
+// SetCell sets a cell; cells outside the buffer are ignored
+func (b *Buffer) SetCell(x, y int, ch rune, fg, bg Color) {
+	if x < 0 || x >= b.Width || y < 0 || y >= b.Height {
+		return
+	}
+	b.Cells[y*b.Width+x] = Cell{Ch: ch, Fg: fg, Bg: bg}
+}
+
+// Flush counts the frames drawn into the buffer
+func (b *Buffer) Flush() error {
+	b.Flushes++
+	return nil
+}
+
+// Cell returns the cell at the given position
+func (b *Buffer) Cell(x, y int) Cell {
+	return b.Cells[y*b.Width+x]
+}
+
+// Line returns the characters of one row with trailing spaces trimmed
+func (b *Buffer) Line(y int) string {
+	runes := make([]rune, b.Width)
+	for x := range runes {
+		runes[x] = b.Cell(x, y).Ch
+	}
+	return strings.TrimRight(string(runes), " ")
+}
+
+// String returns the characters of the buffer as text, one line per row
+func (b *Buffer) String() string {
+	var sb strings.Builder
+	for y := 0; y < b.Height; y++ {
+		sb.WriteString(b.Line(y))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
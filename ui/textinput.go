@@ -0,0 +1,175 @@
+package ui
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+// InputResults returned by TextInput.HandleKey
+const (
+	InputEditing = iota
+	InputSubmit
+	InputCancel
+)
+
+// TextInput is a single line text field with cursor editing and history
+type TextInput struct {
+	Label    string
+	MaxLen   int
+	Mask     rune // drawn instead of each character when set, e.g. for passwords
+	Validate func(string) error
+	Error    string
+
+	value   []rune
+	cursor  int
+	history []string
+	histPos int    // -1 while editing a new entry
+	draft   []rune // text being edited before browsing history
+}
+
+// NewTextInput creates an empty text field with the given history, newest first
+func NewTextInput(label string, history []string) *TextInput {
+	return &TextInput{
+		Label:   label,
+		MaxLen:  64,
+		history: history,
+		histPos: -1,
+	}
+}
+
+// Text returns the current contents of the field
+func (t *TextInput) Text() string {
+	return string(t.value)
+}
+
+// SetText replaces the contents of the field and moves the cursor to the end
+func (t *TextInput) SetText(text string) {
+	t.value = []rune(text)
+	t.cursor = len(t.value)
+}
+
+// Cursor returns the cursor position in runes
+func (t *TextInput) Cursor() int {
+	return t.cursor
+}
+
+// HandleKey applies a key event to the field and reports whether the user
+// submitted or cancelled. Submitting runs Validate and stays in editing mode
+// with Error set if the text is rejected.
+func (t *TextInput) HandleKey(ev termbox.Event) int {
+	if ev.Type != termbox.EventKey {
+		return InputEditing
+	}
+
+	switch ev.Key {
+	case termbox.KeyEsc:
+		return InputCancel
+	case termbox.KeyEnter:
+		if t.Validate != nil {
+			if err := t.Validate(t.Text()); err != nil {
+				t.Error = err.Error()
+				return InputEditing
+			}
+		}
+		t.Error = ""
+		return InputSubmit
+	case termbox.KeyArrowLeft:
+		if t.cursor > 0 {
+			t.cursor--
+		}
+	case termbox.KeyArrowRight:
+		if t.cursor < len(t.value) {
+			t.cursor++
+		}
+	case termbox.KeyHome, termbox.KeyCtrlA:
+		t.cursor = 0
+	case termbox.KeyEnd, termbox.KeyCtrlE:
+		t.cursor = len(t.value)
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		if t.cursor > 0 {
+			t.value = append(t.value[:t.cursor-1], t.value[t.cursor:]...)
+			t.cursor--
+		}
+	case termbox.KeyDelete, termbox.KeyCtrlD:
+		if t.cursor < len(t.value) {
+			t.value = append(t.value[:t.cursor], t.value[t.cursor+1:]...)
+		}
+	case termbox.KeyCtrlU:
+		t.value = t.value[:0]
+		t.cursor = 0
+	case termbox.KeyArrowUp:
+		t.browseHistory(1)
+	case termbox.KeyArrowDown:
+		t.browseHistory(-1)
+	case termbox.KeySpace:
+		t.insert(' ')
+	default:
+		if ev.Ch != 0 {
+			t.insert(ev.Ch)
+		}
+	}
+	return InputEditing
+}
+
+// insert adds a rune at the cursor position
+func (t *TextInput) insert(ch rune) {
+	if t.MaxLen > 0 && len(t.value) >= t.MaxLen {
+		return
+	}
+	t.value = append(t.value, 0)
+	copy(t.value[t.cursor+1:], t.value[t.cursor:])
+	t.value[t.cursor] = ch
+	t.cursor++
+	t.Error = ""
+}
+
+// browseHistory moves through the history; positive steps go to older entries
+func (t *TextInput) browseHistory(step int) {
+	pos := t.histPos + step
+	if pos < -1 || pos >= len(t.history) {
+		return
+	}
+	if t.histPos == -1 {
+		t.draft = append([]rune{}, t.value...)
+	}
+	t.histPos = pos
+	if pos == -1 {
+		t.SetText(string(t.draft))
+	} else {
+		t.SetText(t.history[pos])
+	}
+}
+
+// DrawTextInput draws the field's label, contents and cursor starting at x, y
+// within the given width, scrolling the contents to keep the cursor visible
+func DrawTextInput(t *TextInput, x, y, width int) {
+	DrawText(x, y, t.Label, palette.Text, ColorDefault)
+
+	fieldX := x + len([]rune(t.Label))
+	fieldWidth := width - len([]rune(t.Label))
+	if fieldWidth < 1 {
+		return
+	}
+
+	start := 0
+	if t.cursor >= fieldWidth {
+		start = t.cursor - fieldWidth + 1
+	}
+	for i := 0; i < fieldWidth; i++ {
+		ch := ' '
+		if start+i < len(t.value) {
+			ch = t.value[start+i]
+			if t.Mask != 0 {
+				ch = t.Mask
+			}
+		}
+		fg := palette.Selected | AttrUnderline
+		if start+i == t.cursor {
+			fg = palette.Selected | AttrReverse
+		}
+		output.SetCell(fieldX+i, y, ch, fg, ColorDefault)
+	}
+
+	if t.Error != "" {
+		DrawText(fieldX, y+1, t.Error, palette.Error, ColorDefault)
+	}
+}
@@ -0,0 +1,353 @@
+package ui
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultTheme is the theme used unless the player picks another
+const DefaultTheme = "classic"
+
+// builtinThemes is the theme file shipped with the game
+//
+//go:embed themes.txt
+var builtinThemes string
+
+// Palette is the color of each role the screens draw in, resolved for one
+// color mode
+type Palette struct {
+	Own, Opponent    Color
+	Bullet, Obstacle Color
+	Border           Color
+	Flash            Color
+	Burst, BurstFade Color
+	Title, Info      Color
+	Text, Selected   Color
+	Faded, Hint      Color
+	Error            Color
+}
+
+// roles returns the palette's colors by their name in theme files
+func (p *Palette) roles() map[string]*Color {
+	return map[string]*Color{
+		"own":        &p.Own,
+		"opponent":   &p.Opponent,
+		"bullet":     &p.Bullet,
+		"obstacle":   &p.Obstacle,
+		"border":     &p.Border,
+		"flash":      &p.Flash,
+		"burst":      &p.Burst,
+		"burst_fade": &p.BurstFade,
+		"title":      &p.Title,
+		"info":       &p.Info,
+		"text":       &p.Text,
+		"selected":   &p.Selected,
+		"faded":      &p.Faded,
+		"hint":       &p.Hint,
+		"error":      &p.Error,
+	}
+}
+
+// palette is the palette every drawing function uses
+var palette = mustBuiltinPalette()
+
+// mustBuiltinPalette resolves the default theme for basic colors
+func mustBuiltinPalette() Palette {
+	themes, err := ParseThemes(strings.NewReader(builtinThemes))
+	if err != nil {
+		panic(err)
+	}
+	return themes[DefaultTheme].Palette(Colors8)
+}
+
+// SetPalette makes the ui draw in the given palette and returns the
+// previous one
+func SetPalette(p Palette) Palette {
+	previous := palette
+	palette = p
+	return previous
+}
+
+// =============================================================================
+// THEMES
+// =============================================================================
+
+// Theme is a named set of colors for the roles of a Palette, as defined in
+// a theme file
+type Theme struct {
+	Name       string
+	Monochrome bool // drawn without colors, whatever the terminal shows
+	specs      map[string]colorSpec
+}
+
+// colorSpec is what a theme file gives a role: a color in some of the ways
+// a terminal may show it, and attributes
+type colorSpec struct {
+	name    Color // a basic color, if hasName
+	hasName bool
+	index   int // a 256-palette color, or -1
+	rgb     [3]uint8
+	hasRGB  bool
+	attrs   Color
+}
+
+// colorNames are the basic colors by name
+var colorNames = map[string]Color{
+	"default": ColorDefault,
+	"black":   ColorBlack,
+	"red":     ColorRed,
+	"green":   ColorGreen,
+	"yellow":  ColorYellow,
+	"blue":    ColorBlue,
+	"magenta": ColorMagenta,
+	"cyan":    ColorCyan,
+	"white":   ColorWhite,
+}
+
+// attrNames are the attributes by name
+var attrNames = map[string]Color{
+	"bold":      AttrBold,
+	"blink":     AttrBlink,
+	"dim":       AttrDim,
+	"underline": AttrUnderline,
+	"cursive":   AttrCursive,
+	"reverse":   AttrReverse,
+}
+
+// BuiltinThemes returns the themes shipped with the game
+func BuiltinThemes() map[string]*Theme {
+	themes, err := ParseThemes(strings.NewReader(builtinThemes))
+	if err != nil {
+		panic(err)
+	}
+	return themes
+}
+
+// ThemeNames returns the names of the themes in alphabetical order
+func ThemeNames(themes map[string]*Theme) []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseThemes reads a theme file. Errors name the offending line.
+func ParseThemes(r io.Reader) (map[string]*Theme, error) {
+	themes := make(map[string]*Theme)
+	known := (&Palette{}).roles()
+	var theme *Theme
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "["), "]"))
+			if !strings.HasSuffix(line, "]") || name == "" {
+				return nil, fmt.Errorf("line %d: bad theme name %q", n, line)
+			}
+			theme = &Theme{Name: name, specs: make(map[string]colorSpec)}
+			themes[name] = theme
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected role = colors", n)
+		}
+		if theme == nil {
+			return nil, fmt.Errorf("line %d: colors before the first [theme]", n)
+		}
+		key = strings.TrimSpace(key)
+		if key == "monochrome" {
+			mono, err := strconv.ParseBool(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: monochrome: %w", n, err)
+			}
+			theme.Monochrome = mono
+			continue
+		}
+		if _, ok := known[key]; !ok {
+			return nil, fmt.Errorf("line %d: unknown role %q", n, key)
+		}
+		spec, err := parseColorSpec(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n, key, err)
+		}
+		theme.specs[key] = spec
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return themes, nil
+}
+
+// parseColorSpec reads the colors and attributes of a role; a lone #
+// starts a comment
+func parseColorSpec(value string) (colorSpec, error) {
+	spec := colorSpec{index: -1}
+	for _, word := range strings.Fields(value) {
+		if word == "#" {
+			break
+		}
+		if c, ok := colorNames[word]; ok {
+			spec.name, spec.hasName = c, true
+			continue
+		}
+		if a, ok := attrNames[word]; ok {
+			spec.attrs |= a
+			continue
+		}
+		if strings.HasPrefix(word, "#") {
+			rgb, err := strconv.ParseUint(word[1:], 16, 32)
+			if err != nil || len(word) != 7 {
+				return spec, fmt.Errorf("bad color %q, expected #rrggbb", word)
+			}
+			spec.rgb = [3]uint8{uint8(rgb >> 16), uint8(rgb >> 8), uint8(rgb)}
+			spec.hasRGB = true
+			continue
+		}
+		index, err := strconv.Atoi(word)
+		if err != nil || index < 0 || index > 255 {
+			return spec, fmt.Errorf("unknown color or attribute %q", word)
+		}
+		spec.index = index
+	}
+	return spec, nil
+}
+
+// Palette resolves the theme for a color mode. Roles the theme leaves out
+// take the classic colors.
+func (t *Theme) Palette(mode ColorMode) Palette {
+	if t.Monochrome {
+		mode = ColorsNone
+	}
+	specs := t.specs
+	if t.Name != DefaultTheme {
+		specs = make(map[string]colorSpec)
+		for role, spec := range BuiltinThemes()[DefaultTheme].specs {
+			specs[role] = spec
+		}
+		for role, spec := range t.specs {
+			specs[role] = spec
+		}
+	}
+
+	var p Palette
+	for role, color := range p.roles() {
+		if spec, ok := specs[role]; ok {
+			*color = spec.resolve(mode)
+		}
+	}
+	return p
+}
+
+// resolve picks the best color of the spec the mode shows, converting
+// another one when the spec has none for it
+func (s colorSpec) resolve(mode ColorMode) Color {
+	c := ColorDefault
+	switch mode {
+	case ColorsRGB:
+		switch {
+		case s.hasRGB:
+			c = RGB(s.rgb[0], s.rgb[1], s.rgb[2])
+		case s.index >= 0:
+			c = RGB(paletteRGB(s.index))
+		case s.hasName && s.name != ColorDefault:
+			c = RGB(paletteRGB(int(s.name) - 1))
+		}
+	case Colors256:
+		switch {
+		case s.index >= 0:
+			c = Color256(uint8(s.index))
+		case s.hasRGB:
+			c = Color256(nearest256(s.rgb))
+		case s.hasName:
+			c = s.name
+		}
+	case Colors8:
+		switch {
+		case s.hasName:
+			c = s.name
+		case s.hasRGB:
+			c = nearestBasic(s.rgb)
+		case s.index >= 0:
+			r, g, b := paletteRGB(s.index)
+			c = nearestBasic([3]uint8{r, g, b})
+		}
+	}
+	return c | s.attrs
+}
+
+// =============================================================================
+// COLOR CONVERSION
+// =============================================================================
+
+// ansiRGB are the 16 colors at the start of the 256-color palette, as
+// xterm shows them
+var ansiRGB = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// cubeLevels are the component values of the 6x6x6 color cube of the
+// 256-color palette
+var cubeLevels = [6]uint8{0, 95, 135, 175, 215, 255}
+
+// paletteRGB returns a color of the 256-color palette as red, green and
+// blue; -1, the default color, is light gray
+func paletteRGB(index int) (uint8, uint8, uint8) {
+	switch {
+	case index < 0:
+		return 208, 208, 208
+	case index < 16:
+		c := ansiRGB[index]
+		return c[0], c[1], c[2]
+	case index < 232:
+		index -= 16
+		return cubeLevels[index/36], cubeLevels[index/6%6], cubeLevels[index%6]
+	}
+	gray := uint8(8 + 10*(index-232))
+	return gray, gray, gray
+}
+
+// distance returns how far apart two colors are
+func distance(a [3]uint8, r, g, b uint8) int {
+	dr, dg, db := int(a[0])-int(r), int(a[1])-int(g), int(a[2])-int(b)
+	return dr*dr + dg*dg + db*db
+}
+
+// nearestBasic returns the basic color closest to a truecolor one
+func nearestBasic(rgb [3]uint8) Color {
+	best, bestDist := ColorDefault, -1
+	for i := 0; i < 8; i++ {
+		if d := distance(rgb, ansiRGB[i][0], ansiRGB[i][1], ansiRGB[i][2]); bestDist < 0 || d < bestDist {
+			best, bestDist = Color(i)+1, d
+		}
+	}
+	return best
+}
+
+// nearest256 returns the index of the color of the 256-color cube or gray
+// ramp closest to a truecolor one
+func nearest256(rgb [3]uint8) uint8 {
+	best, bestDist := 0, -1
+	for index := 16; index < 256; index++ {
+		r, g, b := paletteRGB(index)
+		if d := distance(rgb, r, g, b); bestDist < 0 || d < bestDist {
+			best, bestDist = index, d
+		}
+	}
+	return uint8(best)
+}
@@ -1,8 +1,17 @@
 package ui
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"shooter-duel/game"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/nsf/termbox-go"
 )
 
 func TestDrawCenteredText(t *testing.T) {
@@ -87,7 +96,7 @@ func TestPlayerRenderingData(t *testing.T) {
 		}
 
 		// check that players have sprites
-		if len(player.Sprite) == 0 {
+		if len(gs.Sprite(player.Sprite).Frames) == 0 {
 			t.Errorf("Player %d should have a sprite", i+1)
 		}
 	}
@@ -166,3 +175,946 @@ func TestScreenDimensions(t *testing.T) {
 		}
 	}
 }
+
+// typeText sends each rune of text to the input as a key event
+func typeText(input *TextInput, text string) {
+	for _, ch := range text {
+		input.HandleKey(termbox.Event{Type: termbox.EventKey, Ch: ch})
+	}
+}
+
+func TestTextInputEditing(t *testing.T) {
+	input := NewTextInput("Host: ", nil)
+	typeText(input, "192.168.1.5")
+
+	if input.Text() != "192.168.1.5" {
+		t.Errorf("Expected '192.168.1.5', got '%s'", input.Text())
+	}
+
+	// move the cursor left and insert in the middle
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowLeft})
+	typeText(input, "0")
+	if input.Text() != "192.168.1.05" {
+		t.Errorf("Expected '192.168.1.05', got '%s'", input.Text())
+	}
+
+	// backspace removes the rune before the cursor
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyBackspace2})
+	if input.Text() != "192.168.1.5" {
+		t.Errorf("Expected '192.168.1.5', got '%s'", input.Text())
+	}
+	if input.Cursor() != 10 {
+		t.Errorf("Expected cursor at 10, got %d", input.Cursor())
+	}
+
+	// delete removes the rune under the cursor
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyHome})
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyDelete})
+	if input.Text() != "92.168.1.5" {
+		t.Errorf("Expected '92.168.1.5', got '%s'", input.Text())
+	}
+}
+
+func TestTextInputHistory(t *testing.T) {
+	input := NewTextInput("Host: ", []string{"newest:8080", "older:8080"})
+	typeText(input, "draft")
+
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowUp})
+	if input.Text() != "newest:8080" {
+		t.Errorf("Expected 'newest:8080', got '%s'", input.Text())
+	}
+
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowUp})
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowUp})
+	if input.Text() != "older:8080" {
+		t.Errorf("Expected 'older:8080', got '%s'", input.Text())
+	}
+
+	// browsing back down restores the draft
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	if input.Text() != "draft" {
+		t.Errorf("Expected 'draft', got '%s'", input.Text())
+	}
+}
+
+func TestTextInputSubmitAndCancel(t *testing.T) {
+	input := NewTextInput("Host: ", nil)
+	input.Validate = func(text string) error {
+		if text == "" {
+			return errors.New("empty")
+		}
+		return nil
+	}
+
+	// validation failures keep the field open
+	result := input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter})
+	if result != InputEditing || input.Error != "empty" {
+		t.Errorf("Expected validation error, got result %d error '%s'", result, input.Error)
+	}
+
+	typeText(input, "host")
+	result = input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter})
+	if result != InputSubmit {
+		t.Errorf("Expected submit, got %d", result)
+	}
+
+	result = input.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc})
+	if result != InputCancel {
+		t.Errorf("Expected cancel, got %d", result)
+	}
+}
+
+func TestCreateRoomScreen(t *testing.T) {
+	cs := NewCreateRoomScreen()
+	if !cs.TLS {
+		t.Error("Encryption should be on by default")
+	}
+
+	// space toggles encryption
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace})
+	if cs.TLS {
+		t.Error("Space should toggle encryption off")
+	}
+
+	// typing in the password field fills the password
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	typeText(cs.Password, "s3cret")
+	if cs.Password.Text() != "s3cret" {
+		t.Errorf("Expected password 's3cret', got '%s'", cs.Password.Text())
+	}
+
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	if result := cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}); result != InputSubmit {
+		t.Errorf("Enter on Create Room should submit, got %d", result)
+	}
+	if result := cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}); result != InputCancel {
+		t.Errorf("Esc should cancel, got %d", result)
+	}
+}
+
+func TestLobbyScreen(t *testing.T) {
+	ls := NewLobbyScreen(game.SeatHost, "Ana")
+	ls.Lobby = *game.NewLobby("Ana")
+	key := func(k termbox.Key) int {
+		return ls.HandleKey(termbox.Event{Type: termbox.EventKey, Key: k})
+	}
+
+	// editing the name is confirmed with enter
+	typeText(ls.Name, "!")
+	if result := key(termbox.KeyEnter); result != LobbyRename || ls.Name.Text() != "Ana!" {
+		t.Errorf("Expected rename to 'Ana!', got %d '%s'", result, ls.Name.Text())
+	}
+
+	// both players pick a ship from the sprite pack
+	key(termbox.KeyArrowDown)
+	if result := key(termbox.KeyArrowRight); result != LobbyShipChanged || ls.Ship != game.Sprites.Ships[1].Name {
+		t.Errorf("Expected the next ship %s, got %d %s", game.Sprites.Ships[1].Name, result, ls.Ship)
+	}
+
+	// the host cycles through the settings
+	key(termbox.KeyArrowDown)
+	key(termbox.KeyArrowDown)
+	if result := key(termbox.KeyArrowRight); result != LobbySettingsChanged || ls.Lobby.Settings.Rounds != 3 {
+		t.Errorf("Expected best of 3, got %d rounds", ls.Lobby.Settings.Rounds)
+	}
+
+	// the preview shows the ship the host has until it has our pick
+	buf := useBuffer(t, 80, 24)
+	DrawLobbyScreen(ls, 80, 24)
+	if !strings.Contains(buf.String(), "sending...") || !strings.Contains(buf.String(), strings.TrimSpace(game.Sprites.Ships[0].Frames[0][0])) {
+		t.Errorf("Expected the host's ship previewed while ours is sent, got\n%s", buf)
+	}
+	ls.Lobby.SetShip(game.SeatHost, game.Sprites.Ships[1])
+	DrawLobbyScreen(ls, 80, 24)
+	if strings.Contains(buf.String(), "sending...") {
+		t.Error("Expected no note once the host has our ship")
+	}
+
+	// the client only has the name, ship and ready fields
+	client := NewLobbyScreen(game.SeatGuest, "Bo")
+	if client.Ship != game.Sprites.Ships[1].Name {
+		t.Errorf("Expected the client to start with the second ship, got %s", client.Ship)
+	}
+	client.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	client.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowDown})
+	if client.Selected != LobbyFieldReady {
+		t.Errorf("Client should go straight to the ready button, got field %d", client.Selected)
+	}
+	if result := client.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}); result != LobbyToggleReady {
+		t.Errorf("Space should toggle ready, got %d", result)
+	}
+	if result := client.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}); result != LobbyLeave {
+		t.Errorf("Esc should leave the lobby, got %d", result)
+	}
+}
+
+func TestChat(t *testing.T) {
+	chat := NewChat()
+	now := time.Now()
+
+	// typing a message and pressing enter sends it and closes the box
+	chat.Open()
+	typeText(chat.Input, "hi there")
+	text, ok := chat.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter})
+	if !ok || text != "hi there" {
+		t.Errorf("Expected to send 'hi there', got %q (%v)", text, ok)
+	}
+	if chat.IsOpen() {
+		t.Error("Chat box should close after sending")
+	}
+
+	// empty messages and esc send nothing
+	chat.Open()
+	if _, ok := chat.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEnter}); ok {
+		t.Error("Empty messages should not be sent")
+	}
+	chat.Open()
+	typeText(chat.Input, "never mind")
+	if _, ok := chat.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}); ok || chat.IsOpen() {
+		t.Error("Esc should close the chat box without sending")
+	}
+
+	// a held quick message key sends the message once per cooldown
+	sent := 0
+	for i := 0; i < 60; i++ {
+		if chat.AllowQuick("gg", now.Add(time.Duration(i)*time.Second/30)) {
+			sent++
+		}
+	}
+	if sent != 2 || !chat.AllowQuick("oops", now) {
+		t.Errorf("Expected 'gg' sent twice in 2 seconds and 'oops' unaffected, got %d", sent)
+	}
+
+	// lines disappear after a while and the log keeps the latest ones
+	for i := 0; i < ChatLines+2; i++ {
+		chat.Add("Ana", "msg", false, now.Add(time.Duration(i)*time.Second))
+	}
+	if len(chat.Lines) != ChatLines {
+		t.Errorf("Expected %d lines kept, got %d", ChatLines, len(chat.Lines))
+	}
+	last := now.Add(time.Duration(ChatLines+1) * time.Second)
+	if len(chat.Visible(last.Add(ChatVisible))) != 0 {
+		t.Error("Old chat lines should fade out")
+	}
+	chat.Open()
+	if len(chat.Visible(last.Add(ChatVisible))) != ChatLines {
+		t.Error("The whole log should show while typing")
+	}
+}
+
+func TestPostGameScreen(t *testing.T) {
+	gs := game.InitGame(true, 80, 24)
+	gs.Players[0].Name = "Ana"
+	gs.Players[1].Name = "Bo"
+	gs.Players[1].Wins = 1
+	gs.IsGameOver = true
+	gs.Winner = 2
+
+	host := NewPostGameScreen(gs, game.SeatHost, nil)
+	if host.Result != "Bo wins!" {
+		t.Errorf("Expected 'Bo wins!', got %q", host.Result)
+	}
+	if client := NewPostGameScreen(gs, game.SeatGuest, nil); client.Result != "You win!" {
+		t.Errorf("Expected 'You win!', got %q", client.Result)
+	}
+
+	// a rematch needs both votes
+	if host.HandleKey(termbox.Event{Type: termbox.EventKey, Ch: 'y'}) != PostGameVote || host.Agreed() {
+		t.Error("Our vote alone should not start a rematch")
+	}
+	if host.HandleKey(termbox.Event{Type: termbox.EventKey, Ch: 'y'}) != PostGameNone {
+		t.Error("Voting twice should not send another vote")
+	}
+	host.Votes[game.SeatGuest] = true
+	if !host.Agreed() {
+		t.Error("Both votes should start a rematch")
+	}
+
+	// once the opponent left any key goes back to the menu
+	host.OpponentLeft = true
+	if host.HandleKey(termbox.Event{Type: termbox.EventKey, Ch: 'y'}) != PostGameLeave {
+		t.Error("Should leave once the opponent is gone")
+	}
+}
+
+func TestViewport(t *testing.T) {
+	// larger terminals center the arena with the hud strip and help line
+	vp := NewViewport(100, 30, 80, 22)
+	if !vp.Fits() || !vp.Framed() {
+		t.Error("80x22 arena should fit a 100x30 terminal with a frame")
+	}
+	if x, y := vp.ToScreen(0, 0); x != 10 || y != 4 {
+		t.Errorf("Expected arena origin at 10,4, got %d,%d", x, y)
+	}
+	if vp.HUDRow() != 2 || vp.FooterRow() != 27 {
+		t.Errorf("Expected the hud on row 2 and the help on row 27, got %d and %d", vp.HUDRow(), vp.FooterRow())
+	}
+
+	// an exact fit leaves one row above and below for the hud and help line
+	vp = NewViewport(80, 24, 80, 22)
+	if !vp.Fits() || vp.Framed() || vp.X != 0 || vp.Y != 1 {
+		t.Errorf("Expected an unframed exact fit at 0,1, got %+v", vp)
+	}
+	if vp.HUDRow() != 0 || vp.FooterRow() != 23 {
+		t.Errorf("Expected the hud on row 0 and the help on row 23, got %d and %d", vp.HUDRow(), vp.FooterRow())
+	}
+
+	// too small in either direction, counting the hud and help rows
+	if NewViewport(79, 30, 80, 22).Fits() || NewViewport(100, 23, 80, 22).Fits() {
+		t.Error("Arena should not fit a terminal smaller in either direction")
+	}
+}
+
+func TestHUD(t *testing.T) {
+	if got := HealthBar(2, 5); got != "██░░░" {
+		t.Errorf("Expected 2 of 5 health, got %q", got)
+	}
+	if got := HealthBar(-1, 3); got != "░░░" {
+		t.Errorf("Expected an empty bar, got %q", got)
+	}
+	if got := FormatElapsed(1300); got != "01:05" {
+		t.Errorf("Expected 01:05, got %s", got)
+	}
+	if FormatRTT(0) != "RTT --" || FormatRTT(42*time.Millisecond) != "RTT 42ms" {
+		t.Errorf("Unexpected round trip times %q, %q", FormatRTT(0), FormatRTT(42*time.Millisecond))
+	}
+
+	// the hud never draws into the arena, even when it is narrow
+	buf := useBuffer(t, 40, 18)
+	gs := game.InitMatch(true, 40, 16, game.MatchSettings{Health: 10, Rounds: 5, Map: game.MapOpen, BulletSpeed: 1})
+	gs.Players[0].Name = "a_rather_long_name"
+	gs.Players[1].Name = "another_long_name"
+	gs.Players = gs.Players[:2]
+	ms := NewMatchScreen(gs, game.SeatHost)
+	ms.RTT = 30 * time.Millisecond
+	DrawMatch(gs, PlayerView(gs, game.SeatHost), ms, time.Now())
+	hud := buf.Line(0)
+	if hud != "You (a_r ██████████  ██████████ Opponent" {
+		t.Errorf("Unexpected hud %q", hud)
+	}
+	if buf.Line(1) != "" {
+		t.Errorf("Expected the arena's first row to be empty, got %q", buf.Line(1))
+	}
+
+	// muted sounds are noted when there is room
+	wide := useBuffer(t, 80, 24)
+	gs = game.InitGame(true, 80, 22)
+	ms = NewMatchScreen(gs, game.SeatHost)
+	ms.Muted = true
+	DrawMatch(gs, PlayerView(gs, game.SeatHost), ms, time.Now())
+	if !strings.Contains(wide.Line(0), "RTT --  Muted") {
+		t.Errorf("Expected a muted note in the hud, got %q", wide.Line(0))
+	}
+}
+
+func TestCreateRoomArena(t *testing.T) {
+	cs := NewCreateRoomScreen()
+	if cs.Arena != game.DefaultArena {
+		t.Errorf("Expected the default arena, got %s", cs.Arena)
+	}
+
+	cs.Selected = CreateFieldArena
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowRight})
+	if cs.Arena != game.ArenaSizes[1] {
+		t.Errorf("Expected arena %s, got %s", game.ArenaSizes[1], cs.Arena)
+	}
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowLeft})
+	cs.HandleKey(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowLeft})
+	if cs.Arena != game.ArenaSizes[len(game.ArenaSizes)-1] {
+		t.Errorf("Expected the arena sizes to wrap around, got %s", cs.Arena)
+	}
+}
+
+func TestFlipSprite(t *testing.T) {
+	flipped := FlipSprite(game.Sprites.DefaultShip(0).Frame(0))
+	expected := []string{` \-/ `, ` |,| `, ` \v/ `}
+	for i := range expected {
+		if flipped[i] != expected[i] {
+			t.Errorf("Row %d: expected %q, got %q", i, expected[i], flipped[i])
+		}
+	}
+
+	// flipping twice gives the original sprite back
+	for _, sprite := range [][]string{game.Sprites.DefaultShip(0).Frame(0), game.Sprites.DefaultShip(1).Frame(0), game.Sprites.Bullet.Frame(0)} {
+		again := FlipSprite(FlipSprite(sprite))
+		for i := range sprite {
+			if again[i] != sprite[i] {
+				t.Errorf("Double flip changed %q into %q", sprite[i], again[i])
+			}
+		}
+	}
+}
+
+func TestPlayerView(t *testing.T) {
+	gs := game.InitGame(true, 80, 24)
+
+	// the host starts at the bottom, the client at the top
+	if view := PlayerView(gs, game.SeatHost); view.Flip {
+		t.Error("Host at the bottom should not be flipped")
+	}
+	if view := PlayerView(gs, game.SeatGuest); !view.Flip || view.Seat != game.SeatGuest {
+		t.Error("Client at the top should see the arena flipped")
+	}
+
+	// after swapping sides for a rematch it's the other way round
+	game.SwapSides(gs)
+	if !PlayerView(gs, game.SeatHost).Flip || PlayerView(gs, game.SeatGuest).Flip {
+		t.Error("Swapped sides should flip the host's view instead")
+	}
+}
+
+// useBuffer makes the ui draw into a fresh in-memory screen for one test
+func useBuffer(t *testing.T, w, h int) *Buffer {
+	t.Helper()
+	buf := NewBuffer(w, h)
+	previous := SetScreen(buf)
+	t.Cleanup(func() { SetScreen(previous) })
+	return buf
+}
+
+func TestBufferScreen(t *testing.T) {
+	buf := useBuffer(t, 20, 3)
+
+	DrawText(2, 1, "hello", ColorGreen, ColorDefault)
+	DrawText(18, 1, "clipped", ColorGreen, ColorDefault)
+	if got := buf.Line(1); got != "  hello           cl" {
+		t.Errorf("Unexpected line %q", got)
+	}
+	if cell := buf.Cell(2, 1); cell.Ch != 'h' || cell.Fg != ColorGreen {
+		t.Errorf("Expected a green 'h', got %+v", cell)
+	}
+
+	output.Clear(ColorDefault, ColorDefault)
+	if buf.String() != "\n\n\n" {
+		t.Errorf("Expected a blank buffer after clearing, got %q", buf.String())
+	}
+}
+
+func TestDrawMenuRendersOptions(t *testing.T) {
+	buf := useBuffer(t, 40, 12)
+
+	DrawMenu(MenuOptionJoin, 40, 12)
+	if buf.Flushes != 1 {
+		t.Errorf("Expected one flushed frame, got %d", buf.Flushes)
+	}
+	if got := buf.Line(2); got != "          ONLINE SHOOTER DUEL" {
+		t.Errorf("Unexpected title line %q", got)
+	}
+
+	// the selected option is highlighted
+	line := buf.Line(7)
+	x := len(line) - len("Join Room (Client)")
+	if buf.Cell(x, 7).Fg != ColorGreen || buf.Cell(x, 6).Fg != ColorWhite {
+		t.Error("Only the selected option should be green")
+	}
+}
+
+func TestDrawGameInViewport(t *testing.T) {
+	buf := useBuffer(t, 100, 30)
+	gs := game.InitGame(true, 80, 22)
+
+	DrawGame(gs, PlayerView(gs, game.SeatHost))
+
+	// the arena is centered and framed
+	vp := NewViewport(100, 30, 80, 22)
+	if buf.Cell(vp.X-1, vp.Y-1).Ch != '┌' {
+		t.Error("Expected a frame around the arena")
+	}
+	p := gs.Players[0]
+	x, y := vp.ToScreen(int(p.X), int(p.Y))
+	if got := buf.Cell(x+2, y).Ch; got != '^' {
+		t.Errorf("Expected the host's ship tip at %d,%d, got %q", x+2, y, got)
+	}
+
+	// a terminal smaller than the arena shows the warning instead
+	small := useBuffer(t, 60, 20)
+	DrawGame(gs, PlayerView(gs, game.SeatHost))
+	if got := small.Line(9); got != "                     Terminal too small" {
+		t.Errorf("Expected the too small screen, got %q", got)
+	}
+}
+
+// ===== GOLDEN FRAMES =====
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// goldenSizes are the terminal sizes every screen is rendered at: the
+// default arena exactly, and a large terminal with margins around it
+var goldenSizes = []struct{ w, h int }{
+	{80, 24},
+	{120, 40},
+}
+
+// checkGolden compares the buffer's text with testdata/golden/<name>.txt,
+// rewriting the file instead when the tests run with -update
+func checkGolden(t *testing.T, name string, buf *Buffer) {
+	t.Helper()
+	path := filepath.Join("testdata", "golden", name+".txt")
+	got := buf.String()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Missing golden file (run go test ./ui -update): %v", err)
+	}
+	if got != string(want) {
+		gotLines := strings.Split(got, "\n")
+		wantLines := strings.Split(string(want), "\n")
+		for y := 0; y < len(gotLines) || y < len(wantLines); y++ {
+			var g, w string
+			if y < len(gotLines) {
+				g = gotLines[y]
+			}
+			if y < len(wantLines) {
+				w = wantLines[y]
+			}
+			if g != w {
+				t.Errorf("%s differs at line %d:\nexpected %q\n     got %q", path, y, w, g)
+				return
+			}
+		}
+	}
+}
+
+// goldenMatch returns a best of three match on the pillars map with a bullet
+// in flight from each player
+func goldenMatch() *game.GameState {
+	settings := game.DefaultSettings()
+	settings.Rounds = 3
+	settings.Map = game.MapPillars
+	gs := game.InitMatch(true, game.ArenaWidth, game.ArenaHeight, settings)
+	gs.Elapsed = 1234
+	gs.Players[0].Name = "alice"
+	gs.Players[1].Name = "bob"
+	gs.Players[1].Health = 2
+	gs.Players[0].Wins = 1
+	gs.Bullets = []*game.Bullet{
+		{X: gs.Players[0].X + 2, Y: gs.Players[0].Y - 4, Speed: -1, Sprite: game.SpriteBullet, OwnerID: 1},
+		{X: gs.Players[1].X + 2, Y: gs.Players[1].Y + 5, Speed: 1, Sprite: game.SpriteBullet, OwnerID: 2},
+	}
+	return gs
+}
+
+func TestGoldenFrames(t *testing.T) {
+	screens := []struct {
+		name string
+		draw func(w, h int)
+	}{
+		{"menu", func(w, h int) { DrawMenu(MenuOptionCreate, w, h) }},
+		{"waiting", func(w, h int) { DrawWaitingScreen("Waiting for opponent...\nPress ESC to cancel", w, h) }},
+		{"host", func(w, h int) {
+			DrawHostScreen(&HostScreen{
+				Addrs: []HostAddress{
+					{Interface: "eth0", Address: "192.168.1.20:8080"},
+					{Interface: "wlan0", Address: "[fd12:3456::20]:8080"},
+					{Interface: "docker0", Address: "172.17.0.1:8080", Virtual: true},
+				},
+				Selected:    0,
+				Fingerprint: "3f2a 9c41 07be 55d0 e812 6a9f 0c33 b7e4 91d2 48af 6e05 c3b9 7d10 2f86 a4e7 5b1c",
+			}, w, h)
+		}},
+		{"join", func(w, h int) {
+			js := &JoinScreen{
+				Input: NewTextInput("Host IP: ", nil),
+				Rooms: []RoomEntry{
+					{Name: "alice's room", Address: "10.0.0.5:8080", Players: 1, MaxPlayers: 2, TLS: true, Locked: true, Joinable: true},
+					{Name: "old build", Address: "10.0.0.7:8080", Players: 1, MaxPlayers: 2},
+				},
+				ListFocused: true,
+			}
+			js.Input.SetText("10.0.0.")
+			DrawJoinScreen(js, w, h)
+		}},
+		{"lobby", func(w, h int) {
+			ls := NewLobbyScreen(game.SeatHost, "alice")
+			ls.Lobby = *game.NewLobby("alice")
+			ls.Lobby.Join("bob")
+			ls.Lobby.Players[game.SeatGuest].Ready = true
+			ls.Lobby.Settings.Rounds = 3
+			ls.Selected = LobbyFieldMap
+			DrawLobbyScreen(ls, w, h)
+		}},
+		{"game_host", func(w, h int) {
+			gs := goldenMatch()
+			ms := NewMatchScreen(gs, game.SeatHost)
+			ms.RTT = 23 * time.Millisecond
+			DrawMatch(gs, PlayerView(gs, game.SeatHost), ms, time.Now())
+		}},
+		{"game_client", func(w, h int) {
+			gs := goldenMatch()
+			DrawGame(gs, PlayerView(gs, game.SeatGuest))
+		}},
+		{"game_over", func(w, h int) {
+			gs := goldenMatch()
+			gs.IsGameOver = true
+			gs.Winner = 1
+			DrawGame(gs, PlayerView(gs, game.SeatHost))
+		}},
+		{"post_game", func(w, h int) {
+			gs := goldenMatch()
+			gs.IsGameOver = true
+			gs.Winner = 1
+			gs.Players[0].Wins = 2
+			stats := game.NewMatchStats()
+			stats.Shots[1], stats.Hits[1] = 12, 5
+			stats.Shots[2], stats.Hits[2] = 20, 4
+			ps := NewPostGameScreen(gs, game.SeatGuest, stats)
+			ps.Votes[game.SeatHost] = true
+			DrawPostGameScreen(ps, w, h)
+		}},
+		{"error", func(w, h int) { DrawGameOver(0, "Error: connection refused", "Press ESC to return to the menu", w, h) }},
+	}
+
+	for _, screen := range screens {
+		for _, size := range goldenSizes {
+			name := fmt.Sprintf("%s_%dx%d", screen.name, size.w, size.h)
+			t.Run(name, func(t *testing.T) {
+				buf := useBuffer(t, size.w, size.h)
+				screen.draw(size.w, size.h)
+				checkGolden(t, name, buf)
+			})
+		}
+	}
+
+	// a terminal smaller than the arena
+	t.Run("too_small_60x20", func(t *testing.T) {
+		buf := useBuffer(t, 60, 20)
+		gs := goldenMatch()
+		DrawGame(gs, PlayerView(gs, game.SeatHost))
+		checkGolden(t, "too_small_60x20", buf)
+	})
+}
+
+// countingScreen is a Buffer that counts the cells drawn on it
+type countingScreen struct {
+	*Buffer
+	cells  int
+	clears int
+}
+
+func (c *countingScreen) SetCell(x, y int, ch rune, fg, bg Color) {
+	c.cells++
+	c.Buffer.SetCell(x, y, ch, fg, bg)
+}
+
+func (c *countingScreen) Clear(fg, bg Color) {
+	c.clears++
+	c.Buffer.Clear(fg, bg)
+}
+
+func TestDiffScreen(t *testing.T) {
+	target := &countingScreen{Buffer: NewBuffer(20, 5)}
+	screen := NewDiffScreen(target)
+	previous := SetScreen(screen)
+	t.Cleanup(func() { SetScreen(previous) })
+
+	// the first frame draws every cell
+	DrawText(2, 1, "hello", ColorGreen, ColorDefault)
+	output.Flush()
+	if target.cells != 100 || target.clears != 1 {
+		t.Errorf("Expected a full redraw of 100 cells, got %d cells and %d clears", target.cells, target.clears)
+	}
+	if target.Line(1) != "  hello" {
+		t.Errorf("Unexpected line %q", target.Line(1))
+	}
+
+	// an identical frame sends nothing
+	target.cells = 0
+	output.Clear(ColorDefault, ColorDefault)
+	DrawText(2, 1, "hello", ColorGreen, ColorDefault)
+	output.Flush()
+	if target.cells != 0 {
+		t.Errorf("Expected no cells for an unchanged frame, got %d", target.cells)
+	}
+
+	// only changed cells are sent, including the ones that were erased
+	output.Clear(ColorDefault, ColorDefault)
+	DrawText(3, 1, "hello", ColorGreen, ColorDefault)
+	output.Flush()
+	if target.cells != 5 {
+		t.Errorf("Expected 5 changed cells, got %d", target.cells)
+	}
+	if target.Line(1) != "   hello" || target.clears != 1 {
+		t.Errorf("Unexpected line %q after %d clears", target.Line(1), target.clears)
+	}
+
+	// a resize redraws everything
+	target.Buffer = NewBuffer(10, 3)
+	target.cells = 0
+	output.Clear(ColorDefault, ColorDefault)
+	DrawText(0, 0, "hi", ColorGreen, ColorDefault)
+	output.Flush()
+	if target.cells != 30 || target.clears != 2 {
+		t.Errorf("Expected a full redraw after resizing, got %d cells and %d clears", target.cells, target.clears)
+	}
+
+	stats := screen.Stats()
+	if stats.Frames != 4 || stats.Cells != 30 {
+		t.Errorf("Expected 4 frames with 30 cells in the last, got %+v", stats)
+	}
+	if stats.Max < stats.Last || stats.Average() > stats.Max {
+		t.Errorf("Inconsistent frame times %+v", stats)
+	}
+	screen.ResetStats()
+	if screen.Stats().Frames != 0 {
+		t.Error("Expected the stats to be reset")
+	}
+}
+
+func TestFrameBudget(t *testing.T) {
+	screen := NewDiffScreen(NewBuffer(4, 1))
+	screen.record(FrameBudget/2, 1)
+	screen.record(FrameBudget*2, 1)
+	if stats := screen.Stats(); stats.OverBudget != 1 || stats.Max != FrameBudget*2 {
+		t.Errorf("Expected one slow frame, got %+v", stats)
+	}
+}
+
+func TestEffects(t *testing.T) {
+	start := time.Now()
+	effects := NewEffects(1)
+
+	// a hit makes the player blink and shakes the arena of the player hit
+	effects.Trigger(game.Event{Kind: game.EventHit, PlayerID: 1, X: 10, Y: 10}, start)
+	if !effects.Flash(1, start) || effects.Flash(1, start.Add(FlashPeriod)) || effects.Flash(2, start) {
+		t.Error("Expected only player 1 to blink")
+	}
+	if effects.Shake(start) == 0 || effects.Shake(start) == effects.Shake(start.Add(ShakePeriod)) {
+		t.Error("Expected the arena to shake back and forth")
+	}
+	if !effects.Active(start.Add(FlashDuration / 2)) {
+		t.Error("Expected the flash to be running")
+	}
+	if effects.Active(start.Add(FlashDuration)) || effects.Flash(1, start.Add(FlashDuration)) {
+		t.Error("Expected the animations to be over")
+	}
+
+	// the opponent's death bursts without shaking our arena
+	start = start.Add(time.Second)
+	effects.Trigger(game.Event{Kind: game.EventDeath, PlayerID: 2, X: 40, Y: 10}, start)
+	if effects.Shake(start) != 0 {
+		t.Error("The opponent's death should not shake our arena")
+	}
+	buf := useBuffer(t, 80, 24)
+	vp := NewViewport(80, 24, 80, 22)
+	effects.DrawBursts(vp, start.Add(BurstDuration/5))
+	x, y := vp.ToScreen(40, 10)
+	if buf.Cell(x, y-1).Ch != '*' || buf.Cell(x+2, y).Ch != '*' || buf.Cell(x, y).Ch != ' ' {
+		t.Errorf("Expected burst particles around the ship, got\n%s", buf.String())
+	}
+	if effects.Active(start.Add(BurstDuration)) {
+		t.Error("Expected the burst to be over")
+	}
+}
+
+func TestMatchScreenEffects(t *testing.T) {
+	buf := useBuffer(t, 80, 24)
+	gs := game.InitGame(true, 80, 22)
+	ms := NewMatchScreen(gs, game.SeatHost)
+	now := time.Now()
+
+	// our hit player is drawn red
+	p := gs.Players[0]
+	ms.Effects.Trigger(game.Event{Kind: game.EventHit, PlayerID: p.ID}, now)
+	DrawMatch(gs, PlayerView(gs, game.SeatHost), ms, now)
+	vp := NewViewport(80, 24, 80, 22)
+	x, y := vp.ToScreen(int(p.X)+2, int(p.Y))
+	shaken := x + ms.Effects.Shake(now)
+	if cell := buf.Cell(shaken, y); cell.Ch != '^' || cell.Fg != ColorRed|AttrBold {
+		t.Errorf("Expected a red ship tip, got %+v", cell)
+	}
+
+	// once the animations are over the ship is back in place and color
+	later := now.Add(time.Second)
+	DrawMatch(gs, PlayerView(gs, game.SeatHost), ms, later)
+	if cell := buf.Cell(x, y); cell.Ch != '^' || cell.Fg != ColorYellow {
+		t.Errorf("Expected a yellow ship tip, got %+v", cell)
+	}
+}
+
+func TestThemes(t *testing.T) {
+	themes := BuiltinThemes()
+	for _, name := range []string{"classic", "colorblind", "mono"} {
+		if themes[name] == nil {
+			t.Fatalf("Expected built-in theme %q, got %v", name, ThemeNames(themes))
+		}
+	}
+
+	// the classic theme keeps the original colors
+	classic := themes["classic"].Palette(Colors8)
+	if classic.Own != ColorYellow || classic.Opponent != ColorMagenta || classic.Flash != ColorRed|AttrBold {
+		t.Errorf("Expected the original player colors, got %+v", classic)
+	}
+	if palette != classic {
+		t.Error("Expected the ui to draw in the classic theme by default")
+	}
+
+	// every role of every built-in theme has a color in every mode
+	for _, theme := range themes {
+		for _, mode := range []ColorMode{Colors8, Colors256, ColorsRGB} {
+			p := theme.Palette(mode)
+			for role, c := range p.roles() {
+				if *c == ColorDefault && !theme.Monochrome {
+					t.Errorf("Expected a color for %s in theme %s (%s)", role, theme.Name, mode)
+				}
+			}
+		}
+	}
+
+	// the best color the terminal shows is picked
+	colorblind := themes["colorblind"]
+	if got := colorblind.Palette(Colors8).Own; got != ColorYellow|AttrBold {
+		t.Errorf("Expected yellow in 8 colors, got %#x", got)
+	}
+	if got := colorblind.Palette(Colors256).Own; got != Color256(214)|AttrBold {
+		t.Errorf("Expected color 214 in 256 colors, got %#x", got)
+	}
+	if got := colorblind.Palette(ColorsRGB).Own; got != RGB(0xe6, 0x9f, 0x00)|AttrBold {
+		t.Errorf("Expected #e69f00 in truecolor, got %#x", got)
+	}
+
+	// the mono theme has attributes only
+	mono := themes["mono"].Palette(ColorsRGB)
+	for role, c := range mono.roles() {
+		if *c&^attrMask != 0 {
+			t.Errorf("Expected no color for %s in the mono theme, got %#x", role, *c)
+		}
+	}
+}
+
+func TestSecurityWarningTheme(t *testing.T) {
+	// the warning stands out with attributes alone in the mono theme
+	previous := SetPalette(BuiltinThemes()["mono"].Palette(ColorsNone))
+	defer SetPalette(previous)
+	buf := useBuffer(t, 80, 24)
+	DrawSecurityWarning("10.0.0.5:8080", "aaaa bbbb", "cccc dddd", 80, 24)
+	for _, cell := range buf.Cells {
+		if cell.Fg&AttrReverse == 0 || cell.Fg&^attrMask != 0 || cell.Bg != ColorDefault {
+			t.Fatalf("Expected reversed cells without colors, got %+v", cell)
+		}
+	}
+}
+
+func TestParseThemes(t *testing.T) {
+	// missing colors are converted and missing roles are classic
+	themes, err := ParseThemes(strings.NewReader("[mine]\n# comment\nown = #ff0000 underline # red\n"))
+	if err != nil {
+		t.Fatalf("Failed to parse theme: %v", err)
+	}
+	p := themes["mine"].Palette(Colors8)
+	if p.Own != ColorRed|AttrUnderline {
+		t.Errorf("Expected red underlined, got %#x", p.Own)
+	}
+	if p.Opponent != ColorMagenta {
+		t.Errorf("Expected the classic opponent color, got %#x", p.Opponent)
+	}
+	if got := themes["mine"].Palette(Colors256).Own; got != Color256(196)|AttrUnderline {
+		t.Errorf("Expected color 196, got %#x", got)
+	}
+
+	bad := []string{
+		"own = red",
+		"[mine]\nown red",
+		"[mine]\nhat = red",
+		"[mine]\nown = #12345",
+		"[mine]\nown = 256",
+		"[mine]\nown = sparkly",
+		"[mine]\nmonochrome = maybe",
+	}
+	for _, text := range bad {
+		if _, err := ParseThemes(strings.NewReader(text)); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestColorModes(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		vars map[string]string
+		want ColorMode
+	}{
+		{map[string]string{"TERM": "xterm"}, Colors8},
+		{map[string]string{"TERM": "xterm-256color"}, Colors256},
+		{map[string]string{"TERM": "xterm-256color", "COLORTERM": "truecolor"}, ColorsRGB},
+		{map[string]string{"TERM": "xterm", "COLORTERM": "24bit"}, ColorsRGB},
+		{map[string]string{"TERM": "xterm-256color", "NO_COLOR": "1"}, ColorsNone},
+		{map[string]string{"TERM": "dumb"}, ColorsNone},
+	}
+	for _, tt := range tests {
+		if got := DetectColorMode(env(tt.vars)); got != tt.want {
+			t.Errorf("Expected %s for %v, got %s", tt.want, tt.vars, got)
+		}
+	}
+
+	// monochrome screens keep only the attributes
+	if got := (TermboxScreen{Mode: ColorsNone}).attribute(ColorRed | AttrBold); got != termbox.AttrBold {
+		t.Errorf("Expected bold only, got %#x", got)
+	}
+
+	// truecolor screens convert the basic colors termbox would misread
+	rgb := TermboxScreen{Mode: ColorsRGB}
+	if r, g, b := termbox.AttributeToRGB(rgb.attribute(ColorRed)); r != 205 || g != 0 || b != 0 {
+		t.Errorf("Expected red as RGB, got %d,%d,%d", r, g, b)
+	}
+	if got := rgb.attribute(RGB(1, 2, 3) | AttrBold); got != termbox.RGBToAttribute(1, 2, 3)|termbox.AttrBold {
+		t.Errorf("Expected the RGB color unchanged, got %#x", got)
+	}
+	if got := rgb.attribute(ColorDefault); got != termbox.ColorDefault {
+		t.Errorf("Expected the default color unchanged, got %#x", got)
+	}
+}
+
+func TestWideRunes(t *testing.T) {
+	buf := useBuffer(t, 20, 5)
+
+	// wide runes take two cells, so the text after them moves along
+	DrawText(0, 0, "字a", ColorDefault, ColorDefault)
+	if buf.Cell(0, 0).Ch != '字' || buf.Cell(2, 0).Ch != 'a' {
+		t.Errorf("Expected 'a' after the wide rune, got %q", buf.Line(0))
+	}
+	DrawCenteredText(10, 1, "字字", ColorDefault, ColorDefault)
+	if buf.Cell(8, 1).Ch != '字' || buf.Cell(10, 1).Ch != '字' {
+		t.Errorf("Expected the wide runes centered, got %q", buf.Line(1))
+	}
+
+	vp := NewViewport(20, 5, 10, 3)
+	vp.DrawEntity(0, 0, []string{"(字)"}, ColorDefault, ColorDefault)
+	x, y := vp.ToScreen(0, 0)
+	if buf.Cell(x+3, y).Ch != ')' {
+		t.Errorf("Expected the sprite to span 4 cells, got %q", buf.Line(y))
+	}
+}
+
+func TestShipFrames(t *testing.T) {
+	gs := game.InitGame(true, 80, 22)
+	wasp, _ := game.Sprites.Ship("wasp")
+	game.SetShip(gs, gs.Players[0], wasp)
+	game.SetShip(gs, gs.Players[1], wasp)
+	start := time.Unix(0, 0)
+
+	// ships animate through their frames
+	first := shipFrame(gs, gs.Players[0], start)
+	second := shipFrame(gs, gs.Players[0], start.Add(SpriteFrameInterval))
+	if first[2] == second[2] {
+		t.Errorf("Expected the next frame, got %q twice", first)
+	}
+
+	// ships at the top of the arena point down
+	top := shipFrame(gs, gs.Players[1], start)
+	if top[2] != ` /v\ ` {
+		t.Errorf("Expected the top ship upside down, got %q", top)
+	}
+}
@@ -0,0 +1,184 @@
+package ui
+
+import (
+	"fmt"
+
+	"shooter-duel/game"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// Viewport maps the fixed-size arena onto the terminal. The arena keeps its
+// size whatever the terminal size; larger terminals get it centered with
+// empty margins around it (letterboxing).
+type Viewport struct {
+	X, Y                  int // terminal cell of the arena's top left corner
+	Width, Height         int // arena size
+	TermWidth, TermHeight int
+	Flip                  bool // game objects are mirrored vertically
+}
+
+// NewViewport centers an arena of the given size in the terminal, together
+// with the HUD strip above it and the help line below it
+func NewViewport(termWidth, termHeight, arenaWidth, arenaHeight int) Viewport {
+	rows := arenaHeight + game.HUDRows + game.FooterRows
+	return Viewport{
+		X:          (termWidth - arenaWidth) / 2,
+		Y:          (termHeight-rows)/2 + game.HUDRows,
+		Width:      arenaWidth,
+		Height:     arenaHeight,
+		TermWidth:  termWidth,
+		TermHeight: termHeight,
+	}
+}
+
+// CurrentViewport centers an arena in the terminal at its current size
+func CurrentViewport(arenaWidth, arenaHeight int) Viewport {
+	w, h := output.Size()
+	return NewViewport(w, h, arenaWidth, arenaHeight)
+}
+
+// Fits reports whether the whole arena, the HUD strip and the help line
+// are visible
+func (v Viewport) Fits() bool {
+	return game.Arena{Width: v.Width, Height: v.Height}.Fits(v.TermWidth, v.TermHeight)
+}
+
+// Framed reports whether the terminal has room for a frame around the
+// arena between the HUD strip and the help line
+func (v Viewport) Framed() bool {
+	return v.X >= 1 && v.X+v.Width < v.TermWidth &&
+		v.Y-1 >= game.HUDRows && v.Y+v.Height+game.FooterRows < v.TermHeight
+}
+
+// HUDRow returns the terminal row of the HUD strip, above the frame if any
+func (v Viewport) HUDRow() int {
+	if v.Framed() {
+		return v.Y - 2
+	}
+	return v.Y - 1
+}
+
+// FooterRow returns the terminal row of the help line, below the frame if any
+func (v Viewport) FooterRow() int {
+	if v.Framed() {
+		return v.Y + v.Height + 1
+	}
+	return v.Y + v.Height
+}
+
+// ToScreen converts arena coordinates to terminal coordinates
+func (v Viewport) ToScreen(x, y int) (int, int) {
+	return v.X + x, v.Y + y
+}
+
+// SetCell draws one arena cell; cells outside the arena are clipped
+func (v Viewport) SetCell(x, y int, ch rune, fg, bg Color) {
+	if x < 0 || x >= v.Width || y < 0 || y >= v.Height {
+		return
+	}
+	sx, sy := v.ToScreen(x, y)
+	output.SetCell(sx, sy, ch, fg, bg)
+}
+
+// DrawText draws text at arena coordinates
+func (v Viewport) DrawText(x, y int, text string, fg, bg Color) {
+	for _, ch := range text {
+		if w := runewidth.RuneWidth(ch); w > 0 {
+			v.SetCell(x, y, ch, fg, bg)
+			x += w
+		}
+	}
+}
+
+// DrawCenteredText draws text centered horizontally in the arena
+func (v Viewport) DrawCenteredText(y int, text string, fg, bg Color) {
+	v.DrawText((v.Width-runewidth.StringWidth(text))/2, y, text, fg, bg)
+}
+
+// DrawEntityCell draws one cell of a game object, mirrored when the view is
+// flipped. HUD text is drawn with SetCell and DrawText, which never flip.
+func (v Viewport) DrawEntityCell(x, y int, ch rune, fg, bg Color) {
+	if v.Flip {
+		y = v.Height - 1 - y
+		ch = flipRune(ch)
+	}
+	v.SetCell(x, y, ch, fg, bg)
+}
+
+// DrawEntity draws a game object's sprite at arena coordinates, mirrored
+// when the view is flipped
+func (v Viewport) DrawEntity(x, y int, sprite []string, fg, bg Color) {
+	for row, line := range sprite {
+		col := 0
+		for _, ch := range line {
+			if w := runewidth.RuneWidth(ch); w > 0 {
+				v.DrawEntityCell(x+col, y+row, ch, fg, bg)
+				col += w
+			}
+		}
+	}
+}
+
+// flippedRunes pairs characters that look like each other upside down
+var flippedRunes = map[rune]rune{
+	'^': 'v', 'v': '^',
+	'/': '\\', '\\': '/',
+	'_': '‾', '‾': '_',
+	'\'': ',', ',': '\'',
+}
+
+// flipRune returns the character as seen upside down
+func flipRune(ch rune) rune {
+	if flipped, ok := flippedRunes[ch]; ok {
+		return flipped
+	}
+	return ch
+}
+
+// FlipSprite mirrors a sprite vertically
+func FlipSprite(sprite []string) []string {
+	flipped := make([]string, len(sprite))
+	for i, line := range sprite {
+		runes := []rune(line)
+		for j, ch := range runes {
+			runes[j] = flipRune(ch)
+		}
+		flipped[len(sprite)-1-i] = string(runes)
+	}
+	return flipped
+}
+
+// DrawBorder frames the arena when the terminal has room around it
+func (v Viewport) DrawBorder(fg Color) {
+	if !v.Framed() {
+		return
+	}
+	left, top := v.X-1, v.Y-1
+	right, bottom := v.X+v.Width, v.Y+v.Height
+	for x := left + 1; x < right; x++ {
+		output.SetCell(x, top, '─', fg, ColorDefault)
+		output.SetCell(x, bottom, '─', fg, ColorDefault)
+	}
+	for y := top + 1; y < bottom; y++ {
+		output.SetCell(left, y, '│', fg, ColorDefault)
+		output.SetCell(right, y, '│', fg, ColorDefault)
+	}
+	output.SetCell(left, top, '┌', fg, ColorDefault)
+	output.SetCell(right, top, '┐', fg, ColorDefault)
+	output.SetCell(left, bottom, '└', fg, ColorDefault)
+	output.SetCell(right, bottom, '┘', fg, ColorDefault)
+}
+
+// DrawTooSmall tells the player to enlarge the terminal to see the arena
+func DrawTooSmall(v Viewport) {
+	output.Clear(ColorDefault, ColorDefault)
+	lines := []string{
+		"Terminal too small",
+		fmt.Sprintf("Need %s, have %dx%d", game.Arena{Width: v.Width, Height: v.Height}.Terminal(), v.TermWidth, v.TermHeight),
+		"Enlarge the window to keep playing",
+	}
+	for i, line := range lines {
+		DrawCenteredText(v.TermWidth/2, v.TermHeight/2-1+i, line, palette.Hint, ColorDefault)
+	}
+}